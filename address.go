@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// addressDetail serves the full record of an address by its address code or
+// of one of its buildings by address code and subcode. With parameter asof,
+// the record is returned as it was at that date.
+func (con *connection) addressDetail(w http.ResponseWriter, r *http.Request) {
+	asof := r.URL.Query().Get("asof")
+	if err := validateDate(asof); err != nil {
+		httpError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	vars := mux.Vars(r)
+	d, err := con.store.Detail(r.Context(), vars["adrcd"], asof)
+	if err != nil {
+		storeError(w, err)
+		return
+	}
+	if d == nil {
+		httpError(w, "no such address", http.StatusNotFound)
+		return
+	}
+	if subcd, ok := vars["subcd"]; ok && !d.building(subcd) {
+		httpError(w, "no such building", http.StatusNotFound)
+		return
+	}
+	con.cachePinned(w, r, asof)
+	writeJSON(w, r, d)
+}
+
+// addressHistory serves the versions of an address, the oldest first
+func (con *connection) addressHistory(w http.ResponseWriter, r *http.Request) {
+	versions, err := con.store.History(r.Context(), mux.Vars(r)["adrcd"])
+	if err != nil {
+		storeError(w, err)
+		return
+	}
+	if len(versions) == 0 {
+		httpError(w, "no such address", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, r, versions)
+}
@@ -0,0 +1,263 @@
+// Package addrparse splits free-text Austrian addresses into their components.
+//
+// It knows the conventions used when writing addresses in Austria: a four
+// digit postcode (Postleitzahl) in front of the municipality, the usual
+// abbreviations of street types like Str., Pl. and G., house number ranges
+// like 3-5 and the notation 2/3/5 for house number, stair (Stiege) and door
+// (Tür).
+package addrparse
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Address holds the components of a parsed address. Components which are not
+// part of the input are empty.
+type Address struct {
+	Postcode      string `json:"postcode,omitempty"`
+	Municipality  string `json:"municipality,omitempty"`
+	Locality      string `json:"locality,omitempty"`
+	Street        string `json:"street,omitempty"`
+	HouseNumber   string `json:"housenumber,omitempty"`   // numeric part of the house number
+	Letter        string `json:"letter,omitempty"`        // letter suffix of the house number, eg. a for 12a
+	HouseNumberTo string `json:"housenumberto,omitempty"` // end of a house number range, eg. 5 for 3-5
+	LetterTo      string `json:"letterto,omitempty"`      // letter suffix of the end of a house number range
+	Stair         string `json:"stair,omitempty"`
+	Door          string `json:"door,omitempty"`
+}
+
+var postcodeRe = regexp.MustCompile(`^(?i:A-|AT-)?([1-9][0-9]{3})$`)
+
+// numberPattern matches a house number with range, letters and slash
+// separated stair and door
+const numberPattern = `(\d+)\s*([a-zA-Z])?(?:\s*-\s*(\d+)\s*([a-zA-Z])?)?((?:\s*/\s*[^/]+)*)$`
+
+var numberRe = regexp.MustCompile(`^` + numberPattern)
+
+// houseRe splits text into the part in front of the house number and the
+// house number
+var houseRe = regexp.MustCompile(`^(.*?\D)\s*` + numberPattern)
+
+var stairRe = regexp.MustCompile(`^(?i:stiege|stg\.?|st\.?)\s*(.+)$`)
+var doorRe = regexp.MustCompile(`^(?i:top|tür|tuer)\s*(.+)$`)
+
+// streetTypes are the endings of words which denote a street
+var streetTypes = []string{"straße", "strasse", "gasse", "gässchen", "platz", "weg", "allee", "ring", "gürtel", "kai", "zeile", "promenade", "steig", "lände", "damm", "siedlung", "markt"}
+
+// abbreviations maps the abbreviated endings of street names to the full
+// ending. The abbreviation may make up the whole word, eg. "Str.", or follow
+// a hyphen. Only those marked attached are expanded at the end of a longer
+// word, eg. "Akademiestr."; "g." also ends place names like "Salzburg.".
+var abbreviations = []struct {
+	short, long string
+	attached    bool
+}{
+	{"str.", "straße", true},
+	{"pl.", "platz", true},
+	{"g.", "gasse", false},
+}
+
+// minStem is the minimum length in bytes of the word in front of an attached
+// abbreviation
+const minStem = 3
+
+// Parse splits s into its address components. Parts separated by comma may
+// appear in any order, eg. "1010 Wien, Akademiestr. 2/3/5" or
+// "Eisentürgasse 12a, 3500 Krems an der Donau".
+func Parse(s string) Address {
+	var a Address
+
+	for _, part := range strings.Split(s, ",") {
+		fields := strings.Fields(part)
+		if len(fields) == 0 {
+			continue
+		}
+
+		withPostcode := false
+		if m := postcodeRe.FindStringSubmatch(fields[0]); m != nil && a.Postcode == "" {
+			a.Postcode = m[1]
+			fields = fields[1:]
+			withPostcode = true
+		}
+		text := strings.Join(fields, " ")
+		if text == "" {
+			continue
+		}
+
+		if m := houseRe.FindStringSubmatch(text); m != nil && a.HouseNumber == "" {
+			a.setHouseNumber(m[2:])
+			text = strings.TrimSpace(m[1])
+			a.setStreet(text, withPostcode)
+			continue
+		}
+
+		switch {
+		case a.Street == "" && isStreet(text):
+			a.setStreet(text, withPostcode)
+		case a.Municipality == "":
+			a.Municipality = text
+		case a.Locality == "":
+			a.Locality = text
+		default:
+			a.Locality += " " + text
+		}
+	}
+	return a
+}
+
+// ParseHouseNumber splits s, eg. "12a", "3-5" or "2/3/5", into the
+// components of a house number. It reports false when s does not start with
+// a number, like the name of a farm (Hofname).
+func ParseHouseNumber(s string) (Address, bool) {
+	var a Address
+	m := numberRe.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return a, false
+	}
+	a.setHouseNumber(m[1:])
+	return a, true
+}
+
+// FormatHouseNumber returns the house number of a without stair and door,
+// eg. "12a" or "3-5"
+func (a Address) FormatHouseNumber() string {
+	s := a.HouseNumber + a.Letter
+	if a.HouseNumberTo != "" || a.LetterTo != "" {
+		s += "-" + a.HouseNumberTo + a.LetterTo
+	}
+	return s
+}
+
+// setHouseNumber sets the house number from the submatches of numberPattern
+func (a *Address) setHouseNumber(m []string) {
+	a.HouseNumber, a.Letter = m[0], strings.ToLower(m[1])
+	a.HouseNumberTo, a.LetterTo = m[2], strings.ToLower(m[3])
+	a.parseSlashes(m[4])
+}
+
+// setStreet sets the street from text, which may be preceded by the
+// municipality when the address was written without a comma. When text
+// followed a postcode and the start of the street is unknown, the first word
+// is taken as municipality.
+func (a *Address) setStreet(text string, withPostcode bool) {
+	words := strings.Fields(Expand(text))
+	start := -1
+	for i, w := range words {
+		if isStreet(w) {
+			start = i
+			break
+		}
+	}
+	if start > 0 && isStreetType(words[start]) {
+		// a separate street type like in "Wiener Straße" belongs to the
+		// words in front of it
+		start = 0
+	}
+	if start <= 0 && withPostcode && len(words) > 1 {
+		start = 1
+	}
+	if start > 0 && a.Municipality == "" {
+		a.Municipality = strings.Join(words[:start], " ")
+		words = words[start:]
+	}
+	if a.Street == "" {
+		a.Street = strings.Join(words, " ")
+	}
+}
+
+// parseSlashes assigns the slash separated parts following the house number
+// to stair and door. Parts without a keyword are positional: a single part is
+// the door, two parts are stair and door.
+func (a *Address) parseSlashes(s string) {
+	var plain []string
+	for _, part := range strings.Split(s, "/") {
+		part = strings.TrimSpace(part)
+		switch {
+		case part == "":
+		case stairRe.MatchString(part):
+			a.Stair = stairRe.FindStringSubmatch(part)[1]
+		case doorRe.MatchString(part):
+			a.Door = doorRe.FindStringSubmatch(part)[1]
+		default:
+			plain = append(plain, part)
+		}
+	}
+
+	switch len(plain) {
+	case 0:
+	case 1:
+		if a.Door == "" {
+			a.Door = plain[0]
+		} else {
+			a.Stair = plain[0]
+		}
+	default:
+		a.Stair, a.Door = plain[0], strings.Join(plain[1:], "/")
+	}
+}
+
+// Expand replaces abbreviated street types in s by their full form, eg.
+// "Akademiestr." by "Akademiestraße" and "St. Pöltner Str." by
+// "Sankt Pöltner Straße".
+func Expand(s string) string {
+	words := strings.Fields(s)
+	for i, w := range words {
+		if w == "St." && i < len(words)-1 {
+			words[i] = "Sankt"
+			continue
+		}
+		lower := strings.ToLower(w)
+		for _, abbr := range abbreviations {
+			if !strings.HasSuffix(lower, abbr.short) {
+				continue
+			}
+			stem := w[:len(w)-len(abbr.short)]
+			switch {
+			case stem == "" || stem[len(stem)-1] == '-':
+				r, _ := utf8.DecodeRuneInString(abbr.long)
+				words[i] = stem + string(unicode.ToUpper(r)) + abbr.long[utf8.RuneLen(r):]
+			case abbr.attached && len(stem) >= minStem && isLetters(stem):
+				words[i] = stem + abbr.long
+			}
+			break
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// isLetters reports whether s consists of letters only
+func isLetters(s string) bool {
+	for _, r := range s {
+		if !unicode.IsLetter(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// isStreet reports whether text contains a word denoting a street
+func isStreet(text string) bool {
+	for _, w := range strings.Fields(strings.ToLower(Expand(text))) {
+		w = strings.Trim(w, ".-")
+		for _, t := range streetTypes {
+			if strings.HasSuffix(w, t) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isStreetType reports whether word is a street type on its own, eg. "Gasse"
+func isStreetType(word string) bool {
+	word = strings.ToLower(word)
+	for _, t := range streetTypes {
+		if word == t {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,71 @@
+package addrparse
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Address
+	}{
+		{"1010 Wien, Akademiestr. 2/3/5", Address{Postcode: "1010", Municipality: "Wien", Street: "Akademiestraße", HouseNumber: "2", Stair: "3", Door: "5"}},
+		{"Krems an der Donau, Eisentürgasse 12a", Address{Municipality: "Krems an der Donau", Street: "Eisentürgasse", HouseNumber: "12", Letter: "a"}},
+		{"Eisentürgasse 12a, 3500 Krems an der Donau", Address{Postcode: "3500", Municipality: "Krems an der Donau", Street: "Eisentürgasse", HouseNumber: "12", Letter: "a"}},
+		{"8010 Graz Herrengasse 16", Address{Postcode: "8010", Municipality: "Graz", Street: "Herrengasse", HouseNumber: "16"}},
+		{"Hauptpl. 3-5", Address{Street: "Hauptplatz", HouseNumber: "3", HouseNumberTo: "5"}},
+		{"Wiener Straße 1a-3b", Address{Street: "Wiener Straße", HouseNumber: "1", Letter: "a", HouseNumberTo: "3", LetterTo: "b"}},
+		{"St. Pöltner Str. 12 / Stiege 2 / Top 7", Address{Street: "Sankt Pöltner Straße", HouseNumber: "12", Stair: "2", Door: "7"}},
+		{"Schubert-G. 4", Address{Street: "Schubert-Gasse", HouseNumber: "4"}},
+		{"4020 Linz", Address{Postcode: "4020", Municipality: "Linz"}},
+		// abbreviations are not expanded inside names
+		{"5020 Salzburg.", Address{Postcode: "5020", Municipality: "Salzburg."}},
+		{"Obstr 5", Address{Street: "Obstr", HouseNumber: "5"}},
+	}
+	for _, tt := range tests {
+		if got := Parse(tt.in); got != tt.want {
+			t.Errorf("Parse(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestExpand(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"Akademiestr.", "Akademiestraße"},
+		{"Rathauspl.", "Rathausplatz"},
+		{"Str. 12", "Straße 12"},
+		{"Linzer G.", "Linzer Gasse"},
+		{"Schubert-G.", "Schubert-Gasse"},
+		{"St. Pöltner Str.", "Sankt Pöltner Straße"},
+		{"Salzburg.", "Salzburg."},
+		{"Eisentürg.", "Eisentürg."},
+		{"Obstr", "Obstr"},
+		{"Obstr.", "Obstr."},
+		{"St.", "St."},
+	}
+	for _, tt := range tests {
+		if got := Expand(tt.in); got != tt.want {
+			t.Errorf("Expand(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseHouseNumber(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Address
+		ok   bool
+	}{
+		{"12a", Address{HouseNumber: "12", Letter: "a"}, true},
+		{"3-5", Address{HouseNumber: "3", HouseNumberTo: "5"}, true},
+		{"12 A - 14 b", Address{HouseNumber: "12", Letter: "a", HouseNumberTo: "14", LetterTo: "b"}, true},
+		{"2/3/5", Address{HouseNumber: "2", Stair: "3", Door: "5"}, true},
+		{"2/Stg. 1/4", Address{HouseNumber: "2", Stair: "1", Door: "4"}, true},
+		{" 7/Top 3", Address{HouseNumber: "7", Door: "3"}, true},
+		{"Hofname", Address{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := ParseHouseNumber(tt.in)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("ParseHouseNumber(%q) = %+v, %v, want %+v, %v", tt.in, got, ok, tt.want, tt.ok)
+		}
+	}
+}
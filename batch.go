@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+const maxrowsBatch = 50000     // hard limit for the number of lines of a single batch request
+const maxbytesBatch = 16 << 20 // hard limit for the body size of a single batch request
+const batchWorkers = 8         // number of lines of a batch request geocoded concurrently
+
+var errBatchTooLarge = errors.New("batch request exceeds " + strconv.Itoa(maxrowsBatch) + " lines")
+
+// batchResult is the best match for a single line of a batch request. Address
+// is null when no address matched.
+type batchResult struct {
+	ID      json.RawMessage `json:"id,omitempty"`
+	Address *Address        `json:"address"`
+	Score   float64         `json:"score"`
+	Error   string          `json:"error,omitempty"`
+}
+
+// readBatchJSON reads a JSON array of query messages
+func readBatchJSON(r io.Reader) ([]ftsMessage, error) {
+	var lines []ftsMessage
+	if err := json.NewDecoder(r).Decode(&lines); err != nil {
+		return nil, errors.New("malformed batch request: " + err.Error())
+	}
+	if len(lines) > maxrowsBatch {
+		return nil, errBatchTooLarge
+	}
+	return lines, nil
+}
+
+// readBatchCSV reads lines of the form id,q[,postcode[,citycode[,province]]].
+// A first line starting with the column name id is skipped as header.
+func readBatchCSV(r io.Reader) ([]ftsMessage, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+
+	var lines []ftsMessage
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.New("malformed batch request: " + err.Error())
+		}
+		if len(lines) == 0 && record[0] == "id" {
+			continue
+		}
+		if len(record) < 2 {
+			return nil, errors.New("malformed batch request: line " + strconv.Itoa(len(lines)+1) + " has no query")
+		}
+		if len(lines) == maxrowsBatch {
+			return nil, errBatchTooLarge
+		}
+
+		id, _ := json.Marshal(record[0])
+		msg := ftsMessage{ID: id, Q: record[1]}
+		fields := []*string{&msg.Postcode, &msg.Citycode, &msg.Province}
+		for i := 2; i < len(record) && i-2 < len(fields); i++ {
+			*fields[i-2] = record[i]
+		}
+		lines = append(lines, msg)
+	}
+	return lines, nil
+}
+
+// queryBest returns the best exact match for p or nil when there is none
+func (con *connection) queryBest(ctx context.Context, p *ftsParams) (*Address, error) {
+	p.autocomplete = false
+	p.n = 1
+
+	addresses, err := con.store.Search(ctx, p)
+	if err != nil || len(addresses) == 0 {
+		return nil, err
+	}
+	return &addresses[0], nil
+}
+
+// geocodeBatch geocodes lines with at most batchWorkers concurrent queries.
+// The results are in the order of lines.
+func (con *connection) geocodeBatch(ctx context.Context, lines []ftsMessage) []batchResult {
+	results := make([]batchResult, len(lines))
+	next := make(chan int)
+
+	var wg sync.WaitGroup
+	for i := 0; i < batchWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				res := &results[i]
+				res.ID = lines[i].ID
+
+				p, err := lines[i].params()
+				if err == nil {
+					res.Address, err = con.queryBest(ctx, p)
+				}
+				if res.Address != nil && res.Address.Score != nil {
+					res.Score = *res.Address.Score
+				}
+				if err != nil {
+					res.Error = err.Error()
+				}
+			}
+		}()
+	}
+
+	for i := range lines {
+		if ctx.Err() != nil {
+			break
+		}
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	return results
+}
+
+// batchSearch serves the batch geocoding endpoint. The request body is either
+// a JSON array of query messages or CSV.
+func (con *connection) batchSearch(w http.ResponseWriter, r *http.Request) {
+	body := http.MaxBytesReader(w, r.Body, maxbytesBatch)
+
+	var lines []ftsMessage
+	var err error
+	mediatype, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediatype {
+	case "application/json":
+		lines, err = readBatchJSON(body)
+	case "text/csv":
+		lines, err = readBatchCSV(body)
+	default:
+		httpError(w, "unsupported content type, expected application/json or text/csv", http.StatusUnsupportedMediaType)
+		return
+	}
+	switch {
+	case err == errBatchTooLarge:
+		httpError(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	case err != nil:
+		httpError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	results := con.geocodeBatch(r.Context(), lines)
+	if r.Context().Err() != nil {
+		info("batch request cancelled: " + r.Context().Err().Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if err = json.NewEncoder(w).Encode(results); err != nil {
+		info("writing batch result failed: " + err.Error())
+	}
+}
@@ -1,13 +1,23 @@
 package main
 
 import (
+	"context"
 	"database/sql"
-	"fmt"
+	"errors"
+	"flag"
 	"log"
+	"math"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
@@ -16,159 +26,400 @@ import (
 
 // Address struct is the response returned after a request for addresses
 type Address struct {
+	ADRCD *string // BEV address code, a stable reference to the address
+	SUBCD *string `json:",omitempty"` // BEV subcode of a building of the address
+
 	PLZ, Gemeindename, Ortsname, Strassenname, Hausnr *string
-	LatlongX, LatlongY                                *float64
+	Bezirk, Bezirksname                               *string // political district by Bezirkskennziffer, in Vienna the Gemeindebezirk
+
+	// house number parts as published by BEV, Hausnr being the first number
+	HausnrBuchstabe1, HausnrVerbindung1, HausnrZahl2, HausnrBuchstabe2 *string
+	HausnrBereich, HausnrText, Hofname                                 *string
+	HausnrAnzeige                                                      string // house number formatted for display, eg. 12a or 3-5
+
+	LatlongX, LatlongY *float64
+	Distance           *float64 `json:",omitempty"` // distance in meters to the reference point of a reverse search
+	Score              *float64 `json:",omitempty"` // relevance of a full text search result between 0 and 1
+	Fuzzy              bool     `json:",omitempty"` // result of the fuzzy search tier
+}
+
+// derive sets the members of a which are derived from the others
+func (a *Address) derive() {
+	a.HausnrAnzeige = a.formatHausnr()
+	a.Bezirksname = nil
+	if a.Bezirk != nil {
+		if name, ok := districtNames[*a.Bezirk]; ok {
+			a.Bezirksname = &name
+		}
+	}
+}
+
+// formatHausnr joins the house number parts, eg. to 12a, 3-5 or 7 Stiege 2.
+// Addresses without a number are named by the farm name (Hofname).
+func (a *Address) formatHausnr() string {
+	var s string
+	for _, part := range []*string{a.Hausnr, a.HausnrBuchstabe1, a.HausnrVerbindung1, a.HausnrZahl2, a.HausnrBuchstabe2} {
+		if part != nil {
+			s += strings.TrimSpace(*part)
+		}
+	}
+	if a.HausnrText != nil && strings.TrimSpace(*a.HausnrText) != "" {
+		s = strings.TrimSpace(s + " " + strings.TrimSpace(*a.HausnrText))
+	}
+	if s == "" && a.Hofname != nil {
+		s = strings.TrimSpace(*a.Hofname)
+	}
+	return s
 }
 
+// upgrader accepts websocket connections from the origins allowed by the
+// configuration, see configure
 var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool { return true },
+	CheckOrigin: checkOrigin(nil),
+}
+
+// upgrade upgrades the connection of r to a websocket. It returns nil when
+// the upgrade failed, in which case the upgrader already replied to the
+// client.
+func (con *connection) upgrade(w http.ResponseWriter, r *http.Request) *websocket.Conn {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		info("connection upgrade to websocket failed: " + err.Error())
+		upgradeFailures.add(1, endpoint(r.Context()))
+		return nil
+	}
+	return conn
 }
 
+// connection holds the state shared by all handlers
 type connection struct {
-	*sql.DB
-}
-
-const maxrowsFTS = 200
-const defaultrowsFTS = 25
-const nearbymeters = 50 // default distance to search nearby addresses in meter
-const autocomplete = `search @@ (plainto_tsquery('german', $1)::text || ':*')::tsquery`
-const noautocomplete = `search @@ plainto_tsquery('german', $1)`
-
-const fulltextSearchSQL = `select addritems.plz, addritems.gemeindename, addritems.ortsname, addritems.strassenname, addritems.hausnrzahl1, ST_Y(adresse.latlong), ST_X(adresse.latlong)
-from adresse
-inner join addritems
-on addritems.adrcd = adresse.adrcd
-and %s
-and addritems.plz like COALESCE(NULLIF($2, ''), addritems.plz)
-and addritems.gkz like COALESCE(NULLIF($3, ''), addritems.gkz)
-and addritems.bld = COALESCE(CAST(NULLIF($4, '') AS smallint), addritems.bld)
-and CASE ($5 = '' AND $6='') WHEN NOT FALSE THEN TRUE ELSE ST_DWithin(latlong_g, ST_GeomFromText('POINT(' || $5 || ' ' || $6 || ')', 4326)::geography, $7, false) END
-limit $8`
+	store    AddressStore
+	db       *sql.DB     // the database of the PostGIS backend, nil for the memory backend
+	releases *releaseHub // notices about applied releases
 
-func (con *connection) fulltextSearch(w http.ResponseWriter, r *http.Request) {
+	draining chan struct{}  // closed when the server shuts down
+	sessions sync.WaitGroup // websocket connections being served
+}
 
-	var n uint64
-	if nrows := r.URL.Query().Get("n"); nrows != "" {
-		var err error
-		if n, err = strconv.ParseUint(nrows, 10, 8); err != nil {
-			s := "error when parsing parameter n: " + err.Error()
-			info(s)
-			http.Error(w, s, http.StatusBadRequest)
-			return
-		}
-		if n > maxrowsFTS {
-			s := "paramter out of range"
-			info(s)
-			http.Error(w, s, http.StatusBadRequest)
-			return
+// set from the configuration, see configure
+var (
+	maxrowsFTS       uint64  = 200
+	defaultrowsFTS   uint64  = 25
+	nearbymeters     float64 = 50       // default distance to search nearby addresses in meter
+	textSearchConfig         = "german" // PostgreSQL text search configuration
+)
+
+// fuzzyMode selects when the fuzzy search tier is used
+type fuzzyMode int
+
+const (
+	fuzzyFallback fuzzyMode = iota // when full text search yields no result
+	fuzzyAlways                    // instead of full text search
+	fuzzyNever
+)
+
+func parseFuzzy(s string) fuzzyMode {
+	switch s {
+	case "1":
+		return fuzzyAlways
+	case "0":
+		return fuzzyNever
+	}
+	return fuzzyFallback
+}
+
+// ftsParams holds the validated parameters of a full text search, regardless
+// of whether they were passed as url query parameters or as a session message
+type ftsParams struct {
+	q, postcode, citycode, province, lat, lon string
+	district                                  string // Bezirkskennziffer
+	street, housenumber, city, locality       string // structured query
+	asof                                      string // search the addresses valid at this date, YYYY-MM-DD
+	autocomplete                              bool
+	fuzzy                                     fuzzyMode
+	n                                         uint64
+	format                                    outputFormat
+}
+
+// structured reports whether p holds a structured query, in which case q
+// is optional
+func (p *ftsParams) structured() bool {
+	return p.street != "" || p.housenumber != "" || p.city != "" || p.locality != ""
+}
+
+// validate checks the parameter combinations which are not caught while
+// parsing the individual values
+func (p *ftsParams) validate() error {
+	if p.n > maxrowsFTS {
+		return errors.New("paramter out of range")
+	}
+	if (len(p.lat) > 0) != (len(p.lon) > 0) { // Latitude/Longitude: either both parameters are set or none of the two is set
+		return errors.New("lat/lon: either both parameters are set to a value or both have to be empty")
+	}
+	if p.lat != "" {
+		if _, _, err := parseLatLon(p.lat, p.lon); err != nil {
+			return err
 		}
-	} else {
-		n = defaultrowsFTS
 	}
+	if err := validatePostcode(p.postcode); err != nil {
+		return err
+	}
+	var err error
+	if p.province, err = parseProvince(p.province); err != nil {
+		return err
+	}
+	if p.district, err = parseDistrict(p.district); err != nil {
+		return err
+	}
+	return validateDate(p.asof)
+}
 
-	lat := r.URL.Query().Get("lat")
-	lon := r.URL.Query().Get("lon")
+// parseLatLon parses the coordinates of a full text search
+func parseLatLon(lat, lon string) (float64, float64, error) {
+	la, err := strconv.ParseFloat(lat, 64)
+	if err != nil || math.IsNaN(la) || la < -90 || la > 90 {
+		return 0, 0, errors.New("parameter lat has to be a latitude between -90 and 90")
+	}
+	lo, err := strconv.ParseFloat(lon, 64)
+	if err != nil || math.IsNaN(lo) || lo < -180 || lo > 180 {
+		return 0, 0, errors.New("parameter lon has to be a longitude between -180 and 180")
+	}
+	return la, lo, nil
+}
 
-	if (len(lat) > 0) != (len(lon) > 0) { // Latitude/Longitude: either both parameters are set or none of the two is set
-		s := "lat/lon: either both parameters are set to a value or both have to be empty"
-		info(s)
-		http.Error(w, s, http.StatusBadRequest)
-		return
+// validateDate checks the value of the parameter asof, which may be empty
+func validateDate(asof string) error {
+	if asof == "" {
+		return nil
+	}
+	if _, err := time.Parse("2006-01-02", asof); err != nil {
+		return errors.New("parameter asof has to be a date of the form YYYY-MM-DD")
 	}
+	return nil
+}
 
-	q := r.URL.Query().Get("q")
-	postcode := r.URL.Query().Get("postcode")
-	citycode := r.URL.Query().Get("citycode")
-	province := r.URL.Query().Get("province")
+func parseFTSParams(v url.Values) (*ftsParams, error) {
+	p := &ftsParams{
+		q:            v.Get("q"),
+		postcode:     v.Get("postcode"),
+		citycode:     v.Get("citycode"),
+		province:     v.Get("province"),
+		district:     v.Get("district"),
+		lat:          v.Get("lat"),
+		lon:          v.Get("lon"),
+		street:       v.Get("street"),
+		housenumber:  v.Get("housenumber"),
+		city:         v.Get("city"),
+		locality:     v.Get("locality"),
+		asof:         v.Get("asof"),
+		autocomplete: v.Get("autocomplete") != "0",
+		fuzzy:        parseFuzzy(v.Get("fuzzy")),
+		n:            defaultrowsFTS,
+	}
 
-	var querystring string
-	if acparam := r.URL.Query().Get("autocomplete"); acparam == "0" {
-		querystring = fmt.Sprintf(fulltextSearchSQL, noautocomplete)
-	} else {
-		querystring = fmt.Sprintf(fulltextSearchSQL, autocomplete)
+	var err error
+	if p.format, err = parseFormat(v.Get("format")); err != nil {
+		return nil, err
 	}
 
-	rows, err := con.Query(querystring, q, postcode, citycode, province, lat, lon, nearbymeters, n)
-	if err != nil {
-		s := "database query failed: " + err.Error()
-		info(s)
-		http.Error(w, s, http.StatusInternalServerError)
-		return
+	if nrows := v.Get("n"); nrows != "" {
+		if p.n, err = strconv.ParseUint(nrows, 10, 8); err != nil {
+			return nil, errors.New("error when parsing parameter n: " + err.Error())
+		}
 	}
-	defer rows.Close()
-	var plz, gemeindename, ortsname, strassenname, hausnrzahl1 *string
-	var latlongy, latlongx *float64
 
-	var addresses []Address
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
 
-	for rows.Next() {
-		if err = rows.Scan(&plz, &gemeindename, &ortsname, &strassenname, &hausnrzahl1, &latlongy, &latlongx); err != nil {
-			s := "reading from database failed: " + err.Error()
-			info(s)
-			http.Error(w, s, http.StatusInternalServerError)
-			return
+// hasQuery reports whether v contains q or any field of a structured query
+func hasQuery(v url.Values) bool {
+	for _, param := range []string{"q", "street", "housenumber", "city", "locality"} {
+		if _, ok := v[param]; ok {
+			return true
 		}
+	}
+	return false
+}
+
+// fulltextSearch serves the websocket endpoint. Clients which pass the query
+// as url parameters receive a single result and the connection gets closed;
+// otherwise the connection is kept open as a search session, see ftsSession.
+func (con *connection) fulltextSearch(w http.ResponseWriter, r *http.Request) {
+	con.sessions.Add(1)
+	defer con.sessions.Done()
 
-		addr := Address{PLZ: plz, Gemeindename: gemeindename, Ortsname: ortsname, Strassenname: strassenname, Hausnr: hausnrzahl1, LatlongY: latlongy, LatlongX: latlongx}
-		addresses = append(addresses, addr)
+	if !hasQuery(r.URL.Query()) {
+		con.fulltextSearchSession(w, r)
+		return
 	}
 
-	conn, err := upgrader.Upgrade(w, r, nil)
+	p, err := parseFTSParams(r.URL.Query())
+	if err != nil {
+		httpError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	addresses, err := con.store.Search(r.Context(), p)
 	if err != nil {
-		s := "connection upgrade to websocket failed: " + err.Error()
-		info(s)
-		http.Error(w, s, http.StatusInternalServerError)
+		storeError(w, err)
+		return
+	}
+
+	conn := con.upgrade(w, r)
+	if conn == nil {
 		return
 	}
 
-	conn.WriteJSON(addresses)
+	conn.WriteJSON(p.format.shape(addresses))
 	conn.Close()
 }
 
-func getDatabaseConnection() (*sql.DB, error) {
-	var dburl string
-
-	if dburl = os.Getenv("DATABASE_URL"); dburl == "" {
-		dburl = "postgres://"
+// withStatementTimeout adds the run-time parameter statement_timeout to the
+// connection parameters dburl, given as url or as keyword/value pairs
+func withStatementTimeout(dburl string, timeout time.Duration) string {
+	ms := strconv.FormatInt(int64(timeout/time.Millisecond), 10)
+	if u, err := url.Parse(dburl); err == nil && (u.Scheme == "postgres" || u.Scheme == "postgresql") {
+		q := u.Query()
+		q.Set("statement_timeout", ms)
+		u.RawQuery = q.Encode()
+		return u.String()
 	}
+	return dburl + " statement_timeout=" + ms
+}
 
+// getDatabaseConnection opens the database of cfg with its pool settings.
+// Statements running longer than statementTimeout are cancelled by the
+// server, 0 disables the timeout.
+func getDatabaseConnection(cfg *config, statementTimeout time.Duration) (*sql.DB, error) {
+	dburl := cfg.DatabaseURL
+	if statementTimeout > 0 {
+		dburl = withStatementTimeout(dburl, statementTimeout)
+	}
 	db, err := sql.Open("postgres", dburl)
 	if err != nil {
 		return nil, err
 	}
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
 	return db, nil
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "import":
+			importCommand(os.Args[2:])
+			return
+		case "config":
+			configCommand(os.Args[2:])
+			return
+		}
+	}
+
+	cfg, err := loadConfig(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		fatal("invalid configuration: " + err.Error())
+	}
+	configure(cfg)
+
 	currdir, _ := filepath.Abs(filepath.Dir(os.Args[0]))
 	info("starting up in " + currdir)
 
-	conn, err := getDatabaseConnection()
-	if err != nil {
-		fatal(err.Error())
+	connection := &connection{releases: newReleaseHub(), draining: make(chan struct{})}
+	if cfg.BEVZip != "" {
+		info("loading addresses from " + cfg.BEVZip)
+		store, err := loadMemStore(cfg.BEVZip)
+		if err != nil {
+			fatal("loading addresses failed: " + err.Error())
+		}
+		info("loaded %d addresses", len(store.addresses))
+		connection.store = store
+	} else {
+		conn, err := getDatabaseConnection(cfg, cfg.QueryTimeout)
+		if err != nil {
+			fatal(err.Error())
+		}
+		store := &postgisStore{DB: conn}
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.StartupTimeout)
+		err = store.checkSchema(ctx)
+		if err == nil {
+			err = store.detectTrigram(ctx)
+		}
+		cancel()
+		if err != nil {
+			fatal("database not usable: " + err.Error())
+		}
+		connection.store = store
+		connection.db = conn
+		go listenReleases(cfg.DatabaseURL, connection.releases)
 	}
-	connection := &connection{DB: conn}
+
+	connection.store = instrumentedStore{connection.store}
 
 	r := mux.NewRouter()
+	r.Use(instrument)
+	r.HandleFunc("/metrics", connection.metricsHandler).Methods("GET")
+	r.HandleFunc("/healthz", healthz).Methods("GET")
+	r.HandleFunc("/readyz", connection.readyz).Methods("GET")
+	r.HandleFunc("/status", connection.status).Methods("GET")
+
 	s := r.PathPrefix("/ws/").Subrouter()
 	s.HandleFunc("/address/fts", connection.fulltextSearch)
+	s.HandleFunc("/address/changes", connection.changesSubscription)
+
+	a := r.PathPrefix("/api/").Subrouter()
+	a.HandleFunc("/address/fts", connection.restFulltextSearch).Methods("GET")
+	a.HandleFunc("/address/reverse", connection.reverseSearch).Methods("GET")
+	a.HandleFunc("/address/batch", connection.batchSearch).Methods("POST")
+	a.HandleFunc("/address/parse", parseAddress).Methods("GET")
+	a.HandleFunc("/address/{adrcd:[0-9]+}", connection.addressDetail).Methods("GET")
+	a.HandleFunc("/address/{adrcd:[0-9]+}/history", connection.addressHistory).Methods("GET")
+	a.HandleFunc("/address/{adrcd:[0-9]+}/{subcd:[0-9]+}", connection.addressDetail).Methods("GET")
+	a.HandleFunc("/address/changes", connection.changeFeed).Methods("GET")
+	a.HandleFunc("/dataset", connection.dataset).Methods("GET")
+	a.HandleFunc("/postcode", connection.postcodes).Methods("GET")
+	a.HandleFunc("/postcode/{plz:[0-9]{4}}", connection.postcode).Methods("GET")
+	a.HandleFunc("/catalogue/provinces", connection.catalogue(levelProvinces)).Methods("GET")
+	a.HandleFunc("/catalogue/provinces/{code:[1-9]}/districts", connection.catalogue(levelDistricts)).Methods("GET")
+	a.HandleFunc("/catalogue/districts/{code:[0-9]{3}}/municipalities", connection.catalogue(levelMunicipalities)).Methods("GET")
+	a.HandleFunc("/catalogue/municipalities/{code:[0-9]{5}}/localities", connection.catalogue(levelLocalities)).Methods("GET")
+	a.HandleFunc("/catalogue/localities/{code:[0-9]+}/streets", connection.catalogue(levelStreets)).Methods("GET")
+	a.HandleFunc("/catalogue/streets/{code:[0-9]+}/housenumbers", connection.houseNumbers).Methods("GET")
+
+	// the requests and their queries are cancelled when the grace period of
+	// the shutdown is over
+	base, cancelQueries := context.WithCancel(context.Background())
+	baseContext := func(net.Listener) context.Context { return base }
 
-	var port, secport string
-	if secport = os.Getenv("SECPORT"); secport != "" {
+	var servers []*http.Server
+	if cfg.SecPort != "" {
+		srv := &http.Server{Addr: cfg.addr(cfg.SecPort), Handler: r, BaseContext: baseContext}
+		servers = append(servers, srv)
 		go func() {
-			if err := http.ListenAndServeTLS(":"+secport, "cert.pem", "key.pem", r); err != nil {
+			if err := srv.ListenAndServeTLS(cfg.CertFile, cfg.KeyFile); err != http.ErrServerClosed {
 				fatal("secure serving failed: " + err.Error())
 			}
 		}()
-		info("serving securely on port " + secport)
+		info("serving securely on " + srv.Addr)
 	}
 
-	if port = os.Getenv("PORT"); port == "" {
-		port = "5000"
-	}
+	srv := &http.Server{Addr: cfg.addr(cfg.Port), Handler: r, BaseContext: baseContext}
+	servers = append(servers, srv)
+	go func() {
+		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+			fatal("serving failed: " + err.Error())
+		}
+	}()
+	info("serving on " + srv.Addr)
 
-	info("serving on port " + port)
-	http.ListenAndServe(":"+port, r)
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	info("received %v, shutting down within %v", <-sig, cfg.ShutdownGrace)
+	connection.shutdown(servers, cfg.ShutdownGrace, cancelQueries)
 }
 
 // Log wrappers
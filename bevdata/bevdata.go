@@ -0,0 +1,270 @@
+// Package bevdata reads the open data release of the BEV Adressregister.
+//
+// A release is a ZIP file of semicolon separated CSV files with a header
+// line: Gemeinde.csv, Ortschaft.csv, Strasse.csv, Adresse.csv and
+// Gebaeude.csv. Coordinates are given in the Austrian Gauß-Krüger
+// projections of the MGI datum, see package mgi.
+package bevdata
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"errors"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/the42/bevaddressapi/mgi"
+)
+
+// Gemeinde is a record of Gemeinde.csv, a municipality
+type Gemeinde struct {
+	GKZ, Gemeindename string
+}
+
+// Ortschaft is a record of Ortschaft.csv, a locality within a municipality
+type Ortschaft struct {
+	GKZ, OKZ, Ortsname string
+}
+
+// Strasse is a record of Strasse.csv, a street within a municipality
+type Strasse struct {
+	SKZ, Strassenname, Strassennamenzusatz, GKZ string
+}
+
+// Adresse is a record of Adresse.csv
+type Adresse struct {
+	ADRCD, GKZ, OKZ, PLZ, SKZ, Zustellbezirk string
+
+	HausnrText, HausnrZahl1, HausnrBuchstabe1, HausnrVerbindung1 string
+	HausnrZahl2, HausnrBuchstabe2, HausnrBereich, GNRAdresse     string
+	Hofname                                                      string
+
+	RW, HW                       float64 // Gauß-Krüger easting and northing
+	EPSG                         int     // projection of RW and HW
+	QuellAdresse, Bestimmungsart string
+}
+
+// Gebaeude is a record of Gebaeude.csv, a building of an address
+type Gebaeude struct {
+	ADRCD, SUBCD, Hauptadresse, HausnrGebaeudeBez string
+
+	RW, HW                       float64
+	EPSG                         int
+	QuellAdresse, Bestimmungsart string
+}
+
+// LatLon returns the WGS84 coordinates of the address. ok is false when the
+// address has no coordinates.
+func (a *Adresse) LatLon() (lat, lon float64, ok bool) {
+	return latLon(a.EPSG, a.RW, a.HW)
+}
+
+// LatLon returns the WGS84 coordinates of the building. ok is false when the
+// building has no coordinates.
+func (g *Gebaeude) LatLon() (lat, lon float64, ok bool) {
+	return latLon(g.EPSG, g.RW, g.HW)
+}
+
+func latLon(epsg int, rw, hw float64) (lat, lon float64, ok bool) {
+	if epsg == 0 {
+		return 0, 0, false
+	}
+	lat, lon, err := mgi.ToWGS84(epsg, rw, hw)
+	return lat, lon, err == nil
+}
+
+// Release is an opened release ZIP
+type Release struct {
+	zr    *zip.ReadCloser
+	files map[string]*zip.File // by lower case base name
+}
+
+// Open opens the release ZIP at name
+func Open(name string) (*Release, error) {
+	zr, err := zip.OpenReader(name)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Release{zr: zr, files: make(map[string]*zip.File)}
+	for _, f := range zr.File {
+		r.files[strings.ToLower(path.Base(f.Name))] = f
+	}
+	return r, nil
+}
+
+// Close closes the release ZIP
+func (r *Release) Close() error {
+	return r.zr.Close()
+}
+
+// Stichtag returns the reference date of the release. The release does not
+// state it, so it is taken from the most recent modification time of its
+// CSV files.
+func (r *Release) Stichtag() time.Time {
+	var stichtag time.Time
+	for name, f := range r.files {
+		if strings.HasSuffix(name, ".csv") && f.Modified.After(stichtag) {
+			stichtag = f.Modified
+		}
+	}
+	y, m, d := stichtag.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// Gemeinden calls fn for every record of Gemeinde.csv
+func (r *Release) Gemeinden(fn func(Gemeinde) error) error {
+	return r.each("Gemeinde.csv", func(rec *record) error {
+		return fn(Gemeinde{GKZ: rec.get("GKZ"), Gemeindename: rec.get("GEMEINDENAME")})
+	})
+}
+
+// Ortschaften calls fn for every record of Ortschaft.csv
+func (r *Release) Ortschaften(fn func(Ortschaft) error) error {
+	return r.each("Ortschaft.csv", func(rec *record) error {
+		return fn(Ortschaft{GKZ: rec.get("GKZ"), OKZ: rec.get("OKZ"), Ortsname: rec.get("ORTSNAME")})
+	})
+}
+
+// Strassen calls fn for every record of Strasse.csv
+func (r *Release) Strassen(fn func(Strasse) error) error {
+	return r.each("Strasse.csv", func(rec *record) error {
+		return fn(Strasse{
+			SKZ:                 rec.get("SKZ"),
+			Strassenname:        rec.get("STRASSENNAME"),
+			Strassennamenzusatz: rec.get("STRASSENNAMENZUSATZ"),
+			GKZ:                 rec.get("GKZ"),
+		})
+	})
+}
+
+// Adressen calls fn for every record of Adresse.csv
+func (r *Release) Adressen(fn func(Adresse) error) error {
+	return r.each("Adresse.csv", func(rec *record) error {
+		a := Adresse{
+			ADRCD:             rec.get("ADRCD"),
+			GKZ:               rec.get("GKZ"),
+			OKZ:               rec.get("OKZ"),
+			PLZ:               rec.get("PLZ"),
+			SKZ:               rec.get("SKZ"),
+			Zustellbezirk:     rec.get("ZUSTELLBEZIRK"),
+			HausnrText:        rec.get("HAUSNRTEXT"),
+			HausnrZahl1:       rec.get("HAUSNRZAHL1"),
+			HausnrBuchstabe1:  rec.get("HAUSNRBUCHSTABE1"),
+			HausnrVerbindung1: rec.get("HAUSNRVERBINDUNG1"),
+			HausnrZahl2:       rec.get("HAUSNRZAHL2"),
+			HausnrBuchstabe2:  rec.get("HAUSNRBUCHSTABE2"),
+			HausnrBereich:     rec.get("HAUSNRBEREICH"),
+			GNRAdresse:        rec.get("GNRADRESSE"),
+			Hofname:           rec.get("HOFNAME"),
+			QuellAdresse:      rec.get("QUELLADRESSE"),
+			Bestimmungsart:    rec.get("BESTIMMUNGSART"),
+		}
+		var err error
+		if a.RW, a.HW, a.EPSG, err = rec.coordinates(); err != nil {
+			return errors.New("address " + a.ADRCD + ": " + err.Error())
+		}
+		return fn(a)
+	})
+}
+
+// Gebaeude calls fn for every record of Gebaeude.csv
+func (r *Release) Gebaeude(fn func(Gebaeude) error) error {
+	return r.each("Gebaeude.csv", func(rec *record) error {
+		g := Gebaeude{
+			ADRCD:             rec.get("ADRCD"),
+			SUBCD:             rec.get("SUBCD"),
+			Hauptadresse:      rec.get("HAUPTADRESSE"),
+			HausnrGebaeudeBez: rec.get("HAUSNRGEBAEUDEBEZ"),
+			QuellAdresse:      rec.get("QUELLADRESSE"),
+			Bestimmungsart:    rec.get("BESTIMMUNGSART"),
+		}
+		var err error
+		if g.RW, g.HW, g.EPSG, err = rec.coordinates(); err != nil {
+			return errors.New("building " + g.ADRCD + "/" + g.SUBCD + ": " + err.Error())
+		}
+		return fn(g)
+	})
+}
+
+// record is a line of a CSV file whose columns are looked up by the names of
+// the header line
+type record struct {
+	columns map[string]int
+	fields  []string
+}
+
+func (rec *record) get(column string) string {
+	if i, ok := rec.columns[column]; ok && i < len(rec.fields) {
+		return strings.TrimSpace(rec.fields[i])
+	}
+	return ""
+}
+
+// coordinates returns RW, HW and EPSG. Addresses without coordinates have
+// EPSG 0.
+func (rec *record) coordinates() (rw, hw float64, epsg int, err error) {
+	if rec.get("EPSG") == "" || rec.get("RW") == "" || rec.get("HW") == "" {
+		return 0, 0, 0, nil
+	}
+	if epsg, err = strconv.Atoi(rec.get("EPSG")); err != nil {
+		return 0, 0, 0, errors.New("malformed EPSG: " + err.Error())
+	}
+	if rw, err = parseFloat(rec.get("RW")); err != nil {
+		return 0, 0, 0, errors.New("malformed RW: " + err.Error())
+	}
+	if hw, err = parseFloat(rec.get("HW")); err != nil {
+		return 0, 0, 0, errors.New("malformed HW: " + err.Error())
+	}
+	return rw, hw, epsg, nil
+}
+
+// parseFloat accepts a decimal point as well as a decimal comma
+func parseFloat(s string) (float64, error) {
+	return strconv.ParseFloat(strings.Replace(s, ",", ".", 1), 64)
+}
+
+// each calls fn for every line but the header of the CSV file name
+func (r *Release) each(name string, fn func(*record) error) error {
+	f, ok := r.files[strings.ToLower(name)]
+	if !ok {
+		return errors.New(name + " missing in release")
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	cr := csv.NewReader(rc)
+	cr.Comma = ';'
+	cr.FieldsPerRecord = -1
+	cr.LazyQuotes = true
+	cr.ReuseRecord = true
+
+	header, err := cr.Read()
+	if err != nil {
+		return errors.New(name + ": reading header failed: " + err.Error())
+	}
+	rec := &record{columns: make(map[string]int, len(header))}
+	for i, column := range header {
+		column = strings.TrimPrefix(column, "\ufeff") // byte order mark
+		rec.columns[strings.ToUpper(strings.TrimSpace(column))] = i
+	}
+
+	for {
+		rec.fields, err = cr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.New(name + ": " + err.Error())
+		}
+		if err = fn(rec); err != nil {
+			return err
+		}
+	}
+}
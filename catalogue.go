@@ -0,0 +1,260 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// provinceNames are the names of the provinces (Bundesländer) by their code,
+// the first digit of the Gemeindekennziffer
+var provinceNames = map[string]string{
+	"1": "Burgenland",
+	"2": "Kärnten",
+	"3": "Niederösterreich",
+	"4": "Oberösterreich",
+	"5": "Salzburg",
+	"6": "Steiermark",
+	"7": "Tirol",
+	"8": "Vorarlberg",
+	"9": "Wien",
+}
+
+// districtNames are the names of the districts (politische Bezirke) by their
+// Bezirkskennziffer, the first three digits of the Gemeindekennziffer. The
+// BEV release does not name the districts. In Vienna, the districts are the
+// 23 Gemeindebezirke.
+var districtNames = map[string]string{
+	"101": "Eisenstadt (Stadt)",
+	"102": "Rust (Stadt)",
+	"103": "Eisenstadt-Umgebung",
+	"104": "Güssing",
+	"105": "Jennersdorf",
+	"106": "Mattersburg",
+	"107": "Neusiedl am See",
+	"108": "Oberpullendorf",
+	"109": "Oberwart",
+
+	"201": "Klagenfurt am Wörthersee (Stadt)",
+	"202": "Villach (Stadt)",
+	"203": "Hermagor",
+	"204": "Klagenfurt-Land",
+	"205": "Sankt Veit an der Glan",
+	"206": "Spittal an der Drau",
+	"207": "Villach-Land",
+	"208": "Völkermarkt",
+	"209": "Wolfsberg",
+	"210": "Feldkirchen",
+
+	"301": "Krems an der Donau (Stadt)",
+	"302": "Sankt Pölten (Stadt)",
+	"303": "Waidhofen an der Ybbs (Stadt)",
+	"304": "Wiener Neustadt (Stadt)",
+	"305": "Amstetten",
+	"306": "Baden",
+	"307": "Bruck an der Leitha",
+	"308": "Gänserndorf",
+	"309": "Gmünd",
+	"310": "Hollabrunn",
+	"311": "Horn",
+	"312": "Korneuburg",
+	"313": "Krems (Land)",
+	"314": "Lilienfeld",
+	"315": "Melk",
+	"316": "Mistelbach",
+	"317": "Mödling",
+	"318": "Neunkirchen",
+	"319": "Sankt Pölten (Land)",
+	"320": "Scheibbs",
+	"321": "Tulln",
+	"322": "Waidhofen an der Thaya",
+	"323": "Wiener Neustadt (Land)",
+	"324": "Wien-Umgebung",
+	"325": "Zwettl",
+
+	"401": "Linz (Stadt)",
+	"402": "Steyr (Stadt)",
+	"403": "Wels (Stadt)",
+	"404": "Braunau am Inn",
+	"405": "Eferding",
+	"406": "Freistadt",
+	"407": "Gmunden",
+	"408": "Grieskirchen",
+	"409": "Kirchdorf an der Krems",
+	"410": "Linz-Land",
+	"411": "Perg",
+	"412": "Ried im Innkreis",
+	"413": "Rohrbach",
+	"414": "Schärding",
+	"415": "Steyr-Land",
+	"416": "Urfahr-Umgebung",
+	"417": "Vöcklabruck",
+	"418": "Wels-Land",
+
+	"501": "Salzburg (Stadt)",
+	"502": "Hallein",
+	"503": "Salzburg-Umgebung",
+	"504": "Sankt Johann im Pongau",
+	"505": "Tamsweg",
+	"506": "Zell am See",
+
+	"601": "Graz (Stadt)",
+	"603": "Deutschlandsberg",
+	"606": "Graz-Umgebung",
+	"610": "Leibnitz",
+	"611": "Leoben",
+	"612": "Liezen",
+	"614": "Murau",
+	"616": "Voitsberg",
+	"617": "Weiz",
+	"620": "Murtal",
+	"621": "Bruck-Mürzzuschlag",
+	"622": "Hartberg-Fürstenfeld",
+	"623": "Südoststeiermark",
+
+	"701": "Innsbruck (Stadt)",
+	"702": "Imst",
+	"703": "Innsbruck-Land",
+	"704": "Kitzbühel",
+	"705": "Kufstein",
+	"706": "Landeck",
+	"707": "Lienz",
+	"708": "Reutte",
+	"709": "Schwaz",
+
+	"801": "Bludenz",
+	"802": "Bregenz",
+	"803": "Dornbirn",
+	"804": "Feldkirch",
+
+	"901": "Innere Stadt",
+	"902": "Leopoldstadt",
+	"903": "Landstraße",
+	"904": "Wieden",
+	"905": "Margareten",
+	"906": "Mariahilf",
+	"907": "Neubau",
+	"908": "Josefstadt",
+	"909": "Alsergrund",
+	"910": "Favoriten",
+	"911": "Simmering",
+	"912": "Meidling",
+	"913": "Hietzing",
+	"914": "Penzing",
+	"915": "Rudolfsheim-Fünfhaus",
+	"916": "Ottakring",
+	"917": "Hernals",
+	"918": "Währing",
+	"919": "Döbling",
+	"920": "Brigittenau",
+	"921": "Floridsdorf",
+	"922": "Donaustadt",
+	"923": "Liesing",
+}
+
+// parseProvince returns the code of the province given as integer, eg. 9 for
+// 09. An empty province stays empty.
+func parseProvince(province string) (string, error) {
+	if province == "" {
+		return "", nil
+	}
+	code, err := strconv.Atoi(province)
+	if err != nil || code < 1 || code > 9 {
+		return "", errors.New("parameter province has to be the code of a province between 1 and 9")
+	}
+	return strconv.Itoa(code), nil
+}
+
+// parseDistrict returns the Bezirkskennziffer of the district given by its
+// code or by its name, eg. 902 or Leopoldstadt. An empty district stays empty.
+func parseDistrict(district string) (string, error) {
+	if district == "" {
+		return "", nil
+	}
+	if len(district) == 3 && strings.Trim(district, "0123456789") == "" {
+		return district, nil
+	}
+	for code, name := range districtNames {
+		if strings.EqualFold(name, district) {
+			return code, nil
+		}
+	}
+	return "", errors.New("parameter district has to be a Bezirkskennziffer or the name of a district, unknown district " + district)
+}
+
+// catalogueLevel is a level of the catalogue, each listing the entries
+// within an entry of the level above
+type catalogueLevel int
+
+const (
+	levelProvinces      catalogueLevel = iota // Bundesländer
+	levelDistricts                            // politische Bezirke by Bezirkskennziffer
+	levelMunicipalities                       // Gemeinden by Gemeindekennziffer (GKZ)
+	levelLocalities                           // Ortschaften by Ortschaftskennziffer (OKZ)
+	levelStreets                              // Straßen by Straßenkennziffer (SKZ)
+	levelPostcodes                            // Postleitzahlen, outside of the hierarchy
+)
+
+// CatalogueEntry is an entry of the catalogue
+type CatalogueEntry struct {
+	Code, Name         string
+	Count              int64    // number of addresses
+	LatlongX, LatlongY *float64 // centroid of the addresses
+}
+
+// nameEntries names the provinces and districts, which are not named by the
+// release
+func nameEntries(level catalogueLevel, entries []CatalogueEntry) {
+	for i := range entries {
+		switch level {
+		case levelProvinces:
+			entries[i].Name = provinceNames[entries[i].Code]
+		case levelDistricts:
+			entries[i].Name = districtNames[entries[i].Code]
+		}
+	}
+}
+
+// catalogue serves the entries of level within the entry given by the path
+// variable code of the level above
+func (con *connection) catalogue(level catalogueLevel) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		parent := mux.Vars(r)["code"]
+
+		entries, err := con.store.Catalogue(r.Context(), level, parent)
+		if err != nil {
+			storeError(w, err)
+			return
+		}
+		if len(entries) == 0 && level != levelProvinces {
+			httpError(w, "no such entry "+parent, http.StatusNotFound)
+			return
+		}
+		nameEntries(level, entries)
+		writeJSON(w, r, entries)
+	}
+}
+
+// houseNumbers serves the addresses of a street, optionally restricted to the
+// locality given by parameter okz
+func (con *connection) houseNumbers(w http.ResponseWriter, r *http.Request) {
+	format, err := parseFormat(r.URL.Query().Get("format"))
+	if err != nil {
+		httpError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	addresses, err := con.store.HouseNumbers(r.Context(), mux.Vars(r)["code"], r.URL.Query().Get("okz"))
+	if err != nil {
+		storeError(w, err)
+		return
+	}
+	if len(addresses) == 0 {
+		httpError(w, "no such street", http.StatusNotFound)
+		return
+	}
+	writeAddresses(w, r, format, addresses)
+}
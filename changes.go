@@ -0,0 +1,308 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const maxrowsChanges = 10000    // hard limit for the number of changes of a page
+const defaultrowsChanges = 1000 // number of changes of a page
+
+// releaseChannel is the channel the import notifies about applied releases
+const releaseChannel = "bevaddress_release"
+
+// errChangesGone is returned when a release was loaded in full after the
+// point the changes were asked for, so they cannot be listed
+var errChangesGone = errors.New("a release was loaded in full since, changes cannot be listed: download the register anew")
+
+// Change is an address which was added, modified or removed by the release
+// of Stichtag. Before and After are the address as it was before and after
+// the release, nil for an added or removed address respectively.
+type Change struct {
+	Seq      int64    `json:"seq"` // cursor to resume the feed after this change
+	Stichtag string   `json:"stichtag"`
+	ADRCD    string   `json:"adrcd"`
+	Type     string   `json:"change"` // added, modified or removed
+	Before   *Address `json:"before"`
+	After    *Address `json:"after"`
+}
+
+// changesParams holds the validated parameters of the change feed
+type changesParams struct {
+	sinceDate string // changes of the releases after the release of this Stichtag, YYYY-MM-DD
+	sinceTime string // changes of the releases loaded after this time, RFC 3339
+	after     int64  // changes after the change with this sequence number
+	n         uint64 // maximum number of changes
+	ndjson    bool
+}
+
+func parseChangesParams(v url.Values) (*changesParams, error) {
+	p := &changesParams{n: defaultrowsChanges}
+
+	if since := v.Get("since"); since != "" {
+		if _, err := time.Parse("2006-01-02", since); err == nil {
+			p.sinceDate = since
+		} else if _, err := time.Parse(time.RFC3339, since); err == nil {
+			p.sinceTime = since
+		} else {
+			return nil, errors.New("parameter since has to be a Stichtag of the form YYYY-MM-DD or an RFC 3339 timestamp")
+		}
+	}
+
+	var err error
+	if after := v.Get("after"); after != "" {
+		if p.after, err = strconv.ParseInt(after, 10, 64); err != nil {
+			return nil, errors.New("error when parsing parameter after: " + err.Error())
+		}
+	}
+	if nrows := v.Get("n"); nrows != "" {
+		if p.n, err = strconv.ParseUint(nrows, 10, 16); err != nil {
+			return nil, errors.New("error when parsing parameter n: " + err.Error())
+		}
+		if p.n == 0 || p.n > maxrowsChanges {
+			return nil, errors.New("parameter n has to be between 1 and " + strconv.Itoa(maxrowsChanges))
+		}
+	}
+
+	switch v.Get("format") {
+	case "", "json":
+	case "ndjson":
+		p.ndjson = true
+	default:
+		return nil, errors.New("parameter format has to be json or ndjson")
+	}
+	return p, nil
+}
+
+// changePage is a page of the change feed. Next is the url of the following
+// page, null on the last page.
+type changePage struct {
+	Changes []*Change `json:"changes"`
+	Next    *string   `json:"next"`
+}
+
+// changeFeed serves the changes of the register between releases, either as
+// pages of JSON or streamed as newline delimited JSON
+func (con *connection) changeFeed(w http.ResponseWriter, r *http.Request) {
+	p, err := parseChangesParams(r.URL.Query())
+	if err != nil {
+		httpError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+
+	if p.ndjson {
+		con.streamChanges(w, r, p)
+		return
+	}
+
+	page := changePage{Changes: []*Change{}}
+	err = con.store.Changes(r.Context(), p, func(c *Change) error {
+		page.Changes = append(page.Changes, c)
+		return nil
+	})
+	switch {
+	case err == errChangesGone:
+		httpError(w, err.Error(), http.StatusGone)
+		return
+	case err != nil:
+		storeError(w, err)
+		return
+	}
+
+	if n := len(page.Changes); uint64(n) == p.n {
+		v := r.URL.Query()
+		v.Set("after", strconv.FormatInt(page.Changes[n-1].Seq, 10))
+		next := r.URL.Path + "?" + v.Encode()
+		page.Next = &next
+	}
+	writeJSON(w, r, page)
+}
+
+// changesError ends a streamed feed which failed part-way
+type changesError struct {
+	Error string `json:"error"`
+}
+
+// streamChanges writes one change per line. The changes are read in pages of
+// defaultrowsChanges, each its own short query which a slow client does not
+// hold open. The status is sent along with the first page, so that errors
+// before can still be reported; a later error ends the stream with a line
+// {"error": "..."}, telling the client that the feed is incomplete.
+func (con *connection) streamChanges(w http.ResponseWriter, r *http.Request, p *changesParams) {
+	enc := json.NewEncoder(w)
+	flusher, _ := w.(http.Flusher)
+	page := *p
+	page.n = defaultrowsChanges
+
+	for first := true; ; first = false {
+		var changes []*Change
+		err := con.store.Changes(r.Context(), &page, func(c *Change) error {
+			changes = append(changes, c)
+			return nil
+		})
+		switch {
+		case err == errChangesGone && first:
+			httpError(w, err.Error(), http.StatusGone)
+			return
+		case err != nil && first:
+			storeError(w, err)
+			return
+		case err != nil:
+			info("streaming changes failed: " + err.Error())
+			enc.Encode(changesError{err.Error()})
+			return
+		}
+
+		if first {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+		}
+		for _, c := range changes {
+			if err := enc.Encode(c); err != nil {
+				info("streaming changes failed: " + err.Error())
+				return
+			}
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		if uint64(len(changes)) < page.n {
+			return
+		}
+		page.after = changes[len(changes)-1].Seq
+	}
+}
+
+// releaseNotice tells subscribers that a release was applied. The changes of
+// the release follow the change with sequence number After in the feed.
+type releaseNotice struct {
+	Run      int64     `json:"run"`
+	Mode     string    `json:"mode"` // full or incremental
+	Stichtag string    `json:"stichtag"`
+	Loaded   time.Time `json:"loaded"`
+	After    int64     `json:"after"`
+	Changes  int64     `json:"changes"`
+}
+
+// releaseHub passes release notices to the subscribed websocket clients
+type releaseHub struct {
+	mu          sync.Mutex
+	subscribers map[chan *releaseNotice]struct{}
+}
+
+func newReleaseHub() *releaseHub {
+	return &releaseHub{subscribers: make(map[chan *releaseNotice]struct{})}
+}
+
+func (h *releaseHub) subscribe() chan *releaseNotice {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	c := make(chan *releaseNotice, 4)
+	h.subscribers[c] = struct{}{}
+	return c
+}
+
+func (h *releaseHub) unsubscribe(c chan *releaseNotice) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.subscribers, c)
+}
+
+// broadcast passes n to all subscribers. A subscriber which does not keep up
+// misses the notice rather than holding up the others.
+func (h *releaseHub) broadcast(n *releaseNotice) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for c := range h.subscribers {
+		select {
+		case c <- n:
+		default:
+			info("dropping release notice for a slow subscriber")
+		}
+	}
+}
+
+// listenReleases forwards the notifications of the import about applied
+// releases to the subscribers of hub
+func listenReleases(dburl string, hub *releaseHub) {
+	l := pq.NewListener(dburl, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			info("release listener: " + err.Error())
+		}
+	})
+	if err := l.Listen(releaseChannel); err != nil {
+		info("listening for releases failed: " + err.Error())
+		return
+	}
+
+	for n := range l.Notify {
+		if n == nil {
+			// the connection was re-established, notifications may have been lost
+			continue
+		}
+		var notice releaseNotice
+		if err := json.Unmarshal([]byte(n.Extra), &notice); err != nil {
+			info("malformed release notification: " + err.Error())
+			continue
+		}
+		hub.broadcast(&notice)
+	}
+}
+
+// changesSubscription serves the websocket endpoint which sends a release
+// notice whenever a release was applied. Clients fetch the changes from the
+// change feed.
+func (con *connection) changesSubscription(w http.ResponseWriter, r *http.Request) {
+	con.sessions.Add(1)
+	defer con.sessions.Done()
+
+	conn := con.upgrade(w, r)
+	if conn == nil {
+		return
+	}
+	defer conn.Close()
+
+	notices := con.releases.subscribe()
+	defer con.releases.unsubscribe(notices)
+
+	// the client does not send messages, reading detects when it went away
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case n := <-notices:
+			conn.SetWriteDeadline(time.Now().Add(sessionWriteWait))
+			if err := conn.WriteJSON(n); err != nil {
+				info("writing release notice failed: " + err.Error())
+				return
+			}
+		case <-closed:
+			return
+		case <-con.draining:
+			closeGoingAway(conn)
+			select {
+			case <-closed:
+			case <-time.After(sessionWriteWait):
+			}
+			return
+		}
+	}
+}
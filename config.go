@@ -0,0 +1,426 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// configFileEnv names the environment variable giving the configuration file
+// when flag -config is not set
+const configFileEnv = "BEVADDRESS_CONFIG"
+
+// config holds the settings of the service. A setting is taken, from highest
+// to lowest precedence, from its command line flag, its environment variable
+// (the flag name in upper case with underscores), the configuration file or
+// its default.
+type config struct {
+	BindAddress       string
+	Port, SecPort     string
+	CertFile, KeyFile string
+
+	DatabaseURL string
+	BEVZip      string
+
+	MaxRows, DefaultRows uint64
+	NearbyMeters         float64
+	TextSearchConfig     string
+	AllowedOrigins       stringList
+
+	MaxOpenConns, MaxIdleConns int
+	ConnMaxLifetime            time.Duration
+	QueryTimeout               time.Duration
+	StartupTimeout             time.Duration
+	ShutdownGrace              time.Duration
+
+	names []string // the settings in the order they were registered
+}
+
+// secretSettings are masked when the configuration is printed
+var secretSettings = map[string]bool{"database-url": true}
+
+// register defines the settings of c as flags of fs
+func (c *config) register(fs *flag.FlagSet) {
+	str := func(p *string, name, value, usage string) {
+		fs.StringVar(p, name, value, usage)
+		c.names = append(c.names, name)
+	}
+	dur := func(p *time.Duration, name string, value time.Duration, usage string) {
+		fs.DurationVar(p, name, value, usage)
+		c.names = append(c.names, name)
+	}
+	integer := func(p *int, name string, value int, usage string) {
+		fs.IntVar(p, name, value, usage)
+		c.names = append(c.names, name)
+	}
+
+	str(&c.BindAddress, "bind-address", "", "IP address to listen on, all interfaces when empty")
+	str(&c.Port, "port", "5000", "TCP port to serve on")
+	str(&c.SecPort, "secport", "", "TCP port to serve TLS on, TLS is not served when empty")
+	str(&c.CertFile, "cert-file", "cert.pem", "certificate of the TLS server")
+	str(&c.KeyFile, "key-file", "key.pem", "private key of the TLS server")
+	str(&c.DatabaseURL, "database-url", "postgres://", "connection parameters of the database")
+	str(&c.BEVZip, "bev-zip", "", "ZIP file of the BEV Adressregister release to hold in memory instead of using the database")
+	fs.Uint64Var(&c.MaxRows, "maxrows", 200, "hard limit for the number of results of a search")
+	fs.Uint64Var(&c.DefaultRows, "defaultrows", 25, "number of results of a search")
+	fs.Float64Var(&c.NearbyMeters, "nearbymeters", 50, "default distance to search nearby addresses in meters")
+	c.names = append(c.names, "maxrows", "defaultrows", "nearbymeters")
+	str(&c.TextSearchConfig, "text-search-config", "german", "PostgreSQL text search configuration of the search vector and the queries")
+	fs.Var(&c.AllowedOrigins, "allowed-origins", "comma separated origins allowed to open websockets, eg. https://example.com; all when empty")
+	c.names = append(c.names, "allowed-origins")
+	integer(&c.MaxOpenConns, "max-open-conns", 0, "maximum number of open database connections, 0 for no limit")
+	integer(&c.MaxIdleConns, "max-idle-conns", 2, "maximum number of idle database connections")
+	dur(&c.ConnMaxLifetime, "conn-max-lifetime", 0, "time after which a database connection is replaced, 0 for never")
+	dur(&c.QueryTimeout, "query-timeout", 10*time.Second, "time a database query may run, 0 for no limit")
+	dur(&c.StartupTimeout, "startup-timeout", 30*time.Second, "time allowed to check the database on startup")
+	dur(&c.ShutdownGrace, "shutdown-grace", 30*time.Second, "time in-flight requests get to complete on shutdown")
+}
+
+// envName returns the environment variable of the setting name
+func envName(name string) string {
+	return strings.ToUpper(strings.Replace(name, "-", "_", -1))
+}
+
+// loadConfig registers the settings as flags of fs, parses args and applies
+// the environment and the configuration file to the settings not given as
+// flags. The configuration file is given by flag -config or by the
+// environment variable BEVADDRESS_CONFIG.
+func loadConfig(fs *flag.FlagSet, args []string) (*config, error) {
+	c := &config{}
+	c.register(fs)
+	file := fs.String("config", "", "configuration file, see the README; defaults to "+configFileEnv)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	onCommandLine := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { onCommandLine[f.Name] = true })
+
+	if *file == "" {
+		*file = os.Getenv(configFileEnv)
+	}
+	if *file != "" {
+		settings, err := readConfigFile(*file)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range settings {
+			if !c.known(s.name) {
+				return nil, fmt.Errorf("%s:%d: unknown setting %s", *file, s.line, s.name)
+			}
+			if onCommandLine[s.name] {
+				continue
+			}
+			if err := fs.Set(s.name, s.value); err != nil {
+				return nil, fmt.Errorf("%s:%d: %s: %v", *file, s.line, s.name, err)
+			}
+		}
+	}
+
+	for _, name := range c.names {
+		if onCommandLine[name] {
+			continue
+		}
+		if v := os.Getenv(envName(name)); v != "" {
+			if err := fs.Set(name, v); err != nil {
+				return nil, fmt.Errorf("environment variable %s: %v", envName(name), err)
+			}
+		}
+	}
+
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// known reports whether name is a setting
+func (c *config) known(name string) bool {
+	for _, n := range c.names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+var identifier = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+// validate checks the settings and their combinations
+func (c *config) validate() error {
+	for name, port := range map[string]string{"port": c.Port, "secport": c.SecPort} {
+		if port == "" && name == "secport" {
+			continue
+		}
+		if n, err := strconv.ParseUint(port, 10, 16); err != nil || n == 0 {
+			return errors.New("setting " + name + " has to be a TCP port, not " + port)
+		}
+	}
+	if c.SecPort != "" {
+		for _, f := range []string{c.CertFile, c.KeyFile} {
+			if _, err := os.Stat(f); err != nil {
+				return errors.New("TLS is served on secport, but " + err.Error())
+			}
+		}
+	}
+	switch {
+	case c.MaxRows == 0 || c.MaxRows > 255:
+		return errors.New("setting maxrows has to be between 1 and 255")
+	case c.DefaultRows == 0 || c.DefaultRows > c.MaxRows:
+		return errors.New("setting defaultrows has to be between 1 and maxrows")
+	case c.NearbyMeters <= 0:
+		return errors.New("setting nearbymeters has to be positive")
+	case !identifier.MatchString(c.TextSearchConfig):
+		// the configuration is part of the SQL statements
+		return errors.New("setting text-search-config has to be the name of a text search configuration, eg. german")
+	case c.MaxOpenConns < 0 || c.MaxIdleConns < 0:
+		return errors.New("settings max-open-conns and max-idle-conns must not be negative")
+	case c.ConnMaxLifetime < 0 || c.QueryTimeout < 0 || c.ShutdownGrace < 0:
+		return errors.New("durations must not be negative")
+	case c.StartupTimeout <= 0:
+		return errors.New("setting startup-timeout has to be positive")
+	}
+	for _, origin := range c.AllowedOrigins {
+		if u, err := url.Parse(origin); err != nil || u.Scheme == "" || u.Host == "" {
+			return errors.New("setting allowed-origins: malformed origin " + origin + ", expected eg. https://example.com")
+		}
+	}
+	return nil
+}
+
+// configure applies the settings of c which are package level state
+func configure(c *config) {
+	maxrowsFTS = c.MaxRows
+	defaultrowsFTS = c.DefaultRows
+	nearbymeters = c.NearbyMeters
+	textSearchConfig = c.TextSearchConfig
+	upgrader.CheckOrigin = checkOrigin(c.AllowedOrigins)
+}
+
+// checkOrigin allows websocket connections from origins only, from any origin
+// when origins is empty. Requests without an Origin header do not come from a
+// browser and are allowed.
+func checkOrigin(origins []string) func(r *http.Request) bool {
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if len(origins) == 0 || origin == "" {
+			return true
+		}
+		for _, o := range origins {
+			if strings.EqualFold(o, origin) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// addr returns the address to listen on port
+func (c *config) addr(port string) string {
+	return c.BindAddress + ":" + port
+}
+
+// write prints c in the format of the configuration file, with secrets
+// masked
+func (c *config) write(w io.Writer, fs *flag.FlagSet) {
+	for _, name := range c.names {
+		var value string
+		switch v := fs.Lookup(name).Value.(flag.Getter).Get().(type) {
+		case string:
+			if secretSettings[name] {
+				v = maskSecrets(v)
+			}
+			value = strconv.Quote(v)
+		case time.Duration:
+			value = strconv.Quote(v.String())
+		case stringList:
+			quoted := make([]string, len(v))
+			for i, s := range v {
+				quoted[i] = strconv.Quote(s)
+			}
+			value = "[" + strings.Join(quoted, ", ") + "]"
+		default:
+			value = fmt.Sprint(v)
+		}
+		fmt.Fprintf(w, "%s = %s\n", name, value)
+	}
+}
+
+var passwordParam = regexp.MustCompile(`password=('(\\.|[^'])*'|\S+)`)
+
+// maskSecrets replaces the password of the connection parameters dburl,
+// given as url or as keyword/value pairs
+func maskSecrets(dburl string) string {
+	if u, err := url.Parse(dburl); err == nil && u.Scheme != "" {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), "xxxxx")
+		}
+		q := u.Query()
+		if q.Get("password") != "" {
+			q.Set("password", "xxxxx")
+			u.RawQuery = q.Encode()
+		}
+		return u.String()
+	}
+	return passwordParam.ReplaceAllString(dburl, "password=xxxxx")
+}
+
+// configCommand prints the configuration the service would run with
+func configCommand(args []string) {
+	fs := flag.NewFlagSet("config", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: bevaddressapi config [flags]")
+		fmt.Fprintln(os.Stderr, "prints the configuration in the format of the configuration file, with secrets masked")
+		fs.PrintDefaults()
+	}
+	c, err := loadConfig(fs, args)
+	if err != nil {
+		fatal("invalid configuration: " + err.Error())
+	}
+	c.write(os.Stdout, fs)
+}
+
+// stringList is a comma separated list of strings
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(s string) error {
+	*l = nil
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+	return nil
+}
+
+func (l *stringList) Get() interface{} {
+	return *l
+}
+
+// configSetting is a setting read from the configuration file
+type configSetting struct {
+	name, value string
+	line        int
+}
+
+// readConfigFile reads the configuration file name. It is written in a
+// subset of TOML: one setting per line as name = value, where value is a
+// string in double or single quotes, a number, a boolean or an array of
+// strings. Comments start with #.
+func readConfigFile(name string) ([]configSetting, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var settings []configSetting
+	sc := bufio.NewScanner(f)
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || text[0] == '#' {
+			continue
+		}
+		if text[0] == '[' {
+			return nil, fmt.Errorf("%s:%d: tables are not supported", name, line)
+		}
+
+		i := strings.IndexByte(text, '=')
+		if i < 0 {
+			return nil, fmt.Errorf("%s:%d: expected name = value", name, line)
+		}
+		key := strings.Replace(strings.TrimSpace(text[:i]), "_", "-", -1)
+		value, err := parseConfigValue(strings.TrimSpace(text[i+1:]))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s: %v", name, line, key, err)
+		}
+		settings = append(settings, configSetting{name: key, value: value, line: line})
+	}
+	return settings, sc.Err()
+}
+
+// parseConfigValue returns the value of a setting as accepted by its flag,
+// arrays joined by comma
+func parseConfigValue(s string) (string, error) {
+	if s != "" && s[0] == '[' {
+		var items []string
+		rest := strings.TrimSpace(s[1:])
+		for rest != "" && rest[0] != ']' {
+			item, r, err := parseConfigScalar(rest)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, item)
+			rest = strings.TrimSpace(r)
+			if rest != "" && rest[0] == ',' {
+				rest = strings.TrimSpace(rest[1:])
+			}
+		}
+		if rest == "" {
+			return "", errors.New("unterminated array")
+		}
+		if err := endOfLine(rest[1:]); err != nil {
+			return "", err
+		}
+		return strings.Join(items, ","), nil
+	}
+
+	value, rest, err := parseConfigScalar(s)
+	if err != nil {
+		return "", err
+	}
+	return value, endOfLine(rest)
+}
+
+// parseConfigScalar parses the string, number or boolean at the start of s
+// and returns the rest of s
+func parseConfigScalar(s string) (value, rest string, err error) {
+	switch {
+	case s == "":
+		return "", "", errors.New("missing value")
+	case s[0] == '"':
+		for i := 1; i < len(s); i++ {
+			switch s[i] {
+			case '\\':
+				i++
+			case '"':
+				value, err = strconv.Unquote(s[:i+1])
+				return value, s[i+1:], err
+			}
+		}
+		return "", "", errors.New("unterminated string")
+	case s[0] == '\'':
+		i := strings.IndexByte(s[1:], '\'')
+		if i < 0 {
+			return "", "", errors.New("unterminated string")
+		}
+		return s[1 : i+1], s[i+2:], nil
+	}
+
+	i := strings.IndexAny(s, ",]# \t")
+	if i < 0 {
+		i = len(s)
+	}
+	return strings.Replace(s[:i], "_", "", -1), s[i:], nil
+}
+
+// endOfLine checks that rest holds nothing but a comment
+func endOfLine(rest string) error {
+	if rest = strings.TrimSpace(rest); rest != "" && rest[0] != '#' {
+		return errors.New("unexpected " + rest)
+	}
+	return nil
+}
@@ -0,0 +1,13 @@
+package main
+
+import "net/http"
+
+// dataset serves the Stichtag and load time of the loaded release
+func (con *connection) dataset(w http.ResponseWriter, r *http.Request) {
+	d, err := con.store.Dataset(r.Context())
+	if err != nil {
+		storeError(w, err)
+		return
+	}
+	writeJSON(w, r, d)
+}
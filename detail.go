@@ -0,0 +1,86 @@
+package main
+
+import "math"
+
+// AddressDetail is the full record of an address
+type AddressDetail struct {
+	Address
+	GKZ, OKZ, SKZ              *string
+	Bundesland, Bundeslandname string        // province code and name
+	Coordinates                []Coordinates // the location in several coordinate reference systems
+	Buildings                  []Building
+}
+
+// Building is a building (Gebäude) of an address, identified by its subcode
+type Building struct {
+	SUBCD              string
+	Hauptadresse       bool    // the address is the main address of the building
+	HausnrGebaeudeBez  *string // designation of the building within the address
+	LatlongX, LatlongY *float64
+	Coordinates        []Coordinates
+}
+
+// Coordinates is a point in the coordinate reference system EPSG. X is the
+// easting or longitude, Y the northing or latitude.
+type Coordinates struct {
+	EPSG int
+	X, Y float64
+}
+
+const (
+	epsgWGS84       = 4326
+	epsgWebMercator = 3857
+)
+
+// pointCoordinates returns the point given by its WGS84 coordinates and by
+// its coordinates as published by BEV in Gauß-Krüger projection epsg in all
+// supported coordinate reference systems
+func pointCoordinates(lat, lon, rw, hw *float64, epsg *int) []Coordinates {
+	coordinates := []Coordinates{}
+	if lat != nil && lon != nil {
+		coordinates = append(coordinates, Coordinates{EPSG: epsgWGS84, X: *lon, Y: *lat})
+		x, y := webMercator(*lat, *lon)
+		coordinates = append(coordinates, Coordinates{EPSG: epsgWebMercator, X: x, Y: y})
+	}
+	if rw != nil && hw != nil && epsg != nil {
+		coordinates = append(coordinates, Coordinates{EPSG: *epsg, X: *rw, Y: *hw})
+	}
+	return coordinates
+}
+
+// webMercator projects WGS84 coordinates to the Web Mercator projection used
+// by web maps
+func webMercator(lat, lon float64) (x, y float64) {
+	const radius = 6378137
+	x = radius * lon * math.Pi / 180
+	y = radius * math.Log(math.Tan(math.Pi/4+lat*math.Pi/360))
+	return x, y
+}
+
+// complete sets the members derived from the location and the codes of d
+func (d *AddressDetail) complete(rw, hw *float64, epsg *int) {
+	if d.GKZ != nil && len(*d.GKZ) > 0 {
+		d.Bundesland = (*d.GKZ)[:1]
+		d.Bundeslandname = provinceNames[d.Bundesland]
+	}
+	d.Address.derive()
+	d.Coordinates = pointCoordinates(d.LatlongY, d.LatlongX, rw, hw, epsg)
+	if d.Buildings == nil {
+		d.Buildings = []Building{}
+	}
+}
+
+// building narrows d down to the building subcd, which provides SUBCD and
+// the location. It reports false when the address has no such building.
+func (d *AddressDetail) building(subcd string) bool {
+	for _, b := range d.Buildings {
+		if b.SUBCD == subcd {
+			d.SUBCD = &b.SUBCD
+			d.LatlongX, d.LatlongY = b.LatlongX, b.LatlongY
+			d.Coordinates = b.Coordinates
+			d.Buildings = []Building{b}
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,42 @@
+package main
+
+import "context"
+
+// fuzzyNames are the names the free-text query is compared to in the fuzzy
+// search tier, a missing name taken as empty. The import indexes this
+// expression, see trigramIndexSQL; concat_ws cannot be indexed as it is not
+// immutable.
+const fuzzyNames = `(COALESCE(addritems.strassenname, '') || ' ' || COALESCE(addritems.ortsname, '') || ' ' || COALESCE(addritems.gemeindename, ''))`
+
+// fuzzyScore is the product of the trigram word similarities of the query
+// and the structured fields to the respective names, decaying with the
+// distance to the reference point, if given
+const fuzzyScore = `CASE WHEN $1 = '' THEN 1 ELSE word_similarity($1, ` + fuzzyNames + `) END
+* CASE WHEN $9 = '' THEN 1 ELSE word_similarity($9, addritems.strassenname) END
+* CASE WHEN $11 = '' THEN 1 ELSE word_similarity($11, addritems.gemeindename) END
+* CASE WHEN $12 = '' THEN 1 ELSE word_similarity($12, addritems.ortsname) END
+* CASE ($5 = '' AND $6 = '') WHEN NOT FALSE THEN 1 ELSE 1 / (1 + ST_Distance(latlong_g, ` + refPoint + `) / $7) END`
+
+// fuzzySearchSQL tolerates typos by matching names using trigram similarity
+// of the pg_trgm extension instead of full text search. It takes the same
+// parameters as fulltextSearchSQL.
+var fuzzySearchSQL = `select ` + addressColumns + `, ` + fuzzyScore + ` as score
+from adresse
+inner join addritems
+on addritems.adrcd = adresse.adrcd
+and ($1 = '' OR $1 <% ` + fuzzyNames + `)` + ftsFilters + `
+and ($9 = '' OR $9 <% addritems.strassenname)
+and ($11 = '' OR $11 <% addritems.gemeindename)
+and ($12 = '' OR $12 <% addritems.ortsname)
+and ($1 <> '' OR $9 <> '' OR $11 <> '' OR $12 <> '')
+order by score desc
+limit $8`
+
+// queryFuzzy runs the search described by p in the fuzzy search tier
+func (pg *postgisStore) queryFuzzy(ctx context.Context, p *ftsParams) ([]Address, error) {
+	addresses, err := pg.queryAddresses(ctx, fuzzySearchSQL, p.args())
+	for i := range addresses {
+		addresses[i].Fuzzy = true
+	}
+	return addresses, err
+}
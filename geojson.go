@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+)
+
+const geoJSONContentType = "application/geo+json"
+
+// outputFormat selects the representation of a list of addresses
+type outputFormat int
+
+const (
+	formatJSON    outputFormat = iota // JSON array of Address
+	formatGeoJSON                     // RFC 7946 FeatureCollection
+)
+
+func parseFormat(s string) (outputFormat, error) {
+	switch s {
+	case "", "json":
+		return formatJSON, nil
+	case "geojson":
+		return formatGeoJSON, nil
+	}
+	return formatJSON, errors.New("unknown format " + s + ", expected json or geojson")
+}
+
+// shape returns the representation of addresses in format f, ready to be
+// encoded as JSON
+func (f outputFormat) shape(addresses []Address) interface{} {
+	if f == formatGeoJSON {
+		return newFeatureCollection(addresses)
+	}
+	return addresses
+}
+
+type geoJSONFeatureCollection struct {
+	Type     string           `json:"type"`
+	Features []geoJSONFeature `json:"features"`
+}
+
+type geoJSONFeature struct {
+	Type       string        `json:"type"`
+	Geometry   *geoJSONPoint `json:"geometry"` // null for addresses without coordinates
+	Properties Address       `json:"properties"`
+}
+
+type geoJSONPoint struct {
+	Type        string     `json:"type"`
+	Coordinates [2]float64 `json:"coordinates"` // longitude, latitude
+}
+
+func newFeatureCollection(addresses []Address) *geoJSONFeatureCollection {
+	fc := &geoJSONFeatureCollection{Type: "FeatureCollection", Features: make([]geoJSONFeature, 0, len(addresses))}
+	for _, addr := range addresses {
+		f := geoJSONFeature{Type: "Feature", Properties: addr}
+		if addr.LatlongX != nil && addr.LatlongY != nil {
+			f.Geometry = &geoJSONPoint{Type: "Point", Coordinates: [2]float64{*addr.LatlongX, *addr.LatlongY}}
+		}
+		fc.Features = append(fc.Features, f)
+	}
+	return fc
+}
+
+// writeAddresses sends addresses as REST response in format f
+func writeAddresses(w http.ResponseWriter, r *http.Request, f outputFormat, addresses []Address) {
+	if addresses == nil {
+		addresses = []Address{}
+	}
+	if f == formatGeoJSON {
+		w.Header().Set("Content-Type", geoJSONContentType)
+	}
+	writeJSON(w, r, f.shape(addresses))
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// version is the build version, set when building with
+// -ldflags "-X main.version=..."
+var version = "dev"
+
+// started is when the process started
+var started = time.Now()
+
+// Status describes the running service and the loaded release
+type Status struct {
+	Version string
+	Started time.Time
+	Uptime  string
+	Dataset *Dataset
+	RowCounts
+}
+
+// healthz tells that the process is alive
+func healthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write([]byte("ok\n"))
+}
+
+// readyz tells whether the service can answer requests: the database is
+// reachable, the schema is present and a release is loaded
+func (con *connection) readyz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-cache")
+	if err := con.store.Ready(r.Context()); err != nil {
+		httpError(w, "not ready: "+err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("ready\n"))
+}
+
+// status serves the build version, the uptime and the loaded release
+func (con *connection) status(w http.ResponseWriter, r *http.Request) {
+	d, err := con.store.Dataset(r.Context())
+	if err != nil {
+		storeError(w, err)
+		return
+	}
+	c, err := con.store.Counts(r.Context())
+	if err != nil {
+		storeError(w, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-cache")
+	writeJSON(w, r, &Status{
+		Version:   version,
+		Started:   started,
+		Uptime:    time.Since(started).Truncate(time.Second).String(),
+		Dataset:   d,
+		RowCounts: *c,
+	})
+}
@@ -0,0 +1,683 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/the42/bevaddressapi/bevdata"
+	"github.com/the42/bevaddressapi/mgi"
+)
+
+// The release is loaded into the staging tables, which either replace
+// adresse, addritems and gebaeude at the end of a full import or are compared
+// to them by an incremental import. Indexes are created without a name, so
+// that PostgreSQL picks names not taken by the indexes of the live tables.
+//
+// Every record is a version, valid from the Stichtag of the release which
+// introduced it until the Stichtag of the release which changed or retired
+// it. The current version has no valid_to.
+const createStagingSQL = `drop table if exists adresse_import, addritems_import, gebaeude_import;
+create table adresse_import (
+	adrcd text not null,
+	gkz text not null,
+	okz text,
+	skz text,
+	plz text,
+	rw double precision,
+	hw double precision,
+	epsg integer,
+	latlong geometry(Point, 4326),
+	latlong_g geography(Point, 4326),
+	valid_from date not null,
+	valid_to date,
+	primary key (adrcd, valid_from)
+);
+create table addritems_import (
+	adrcd text not null,
+	bld smallint not null,
+	gkz text not null,
+	okz text,
+	skz text,
+	plz text,
+	gemeindename text,
+	ortsname text,
+	strassenname text,
+	hausnrzahl1 integer,
+	hausnrbuchstabe1 text,
+	hausnrverbindung1 text,
+	hausnrzahl2 integer,
+	hausnrbuchstabe2 text,
+	hausnrbereich text,
+	hausnrtext text,
+	hofname text,
+	search tsvector,
+	valid_from date not null,
+	valid_to date,
+	primary key (adrcd, valid_from)
+);
+create table gebaeude_import (
+	adrcd text not null,
+	subcd text not null,
+	hauptadresse text,
+	hausnrgebaeudebez text,
+	rw double precision,
+	hw double precision,
+	epsg integer,
+	latlong geometry(Point, 4326),
+	valid_from date not null,
+	valid_to date,
+	primary key (adrcd, subcd, valid_from)
+)`
+
+// importTable is a table loaded from the release
+type importTable struct {
+	name    string
+	key     []string // identifies a record across releases
+	columns []string // copied from the release
+	derived []string // computed from the columns of the release, not compared
+}
+
+var adresseTable = importTable{"adresse", []string{"adrcd"},
+	[]string{"adrcd", "gkz", "okz", "skz", "plz", "rw", "hw", "epsg", "latlong"},
+	[]string{"latlong", "latlong_g"}}
+
+var addritemsTable = importTable{"addritems", []string{"adrcd"},
+	[]string{"adrcd", "bld", "gkz", "okz", "skz", "plz", "gemeindename", "ortsname", "strassenname",
+		"hausnrzahl1", "hausnrbuchstabe1", "hausnrverbindung1", "hausnrzahl2", "hausnrbuchstabe2", "hausnrbereich", "hausnrtext", "hofname"},
+	[]string{"search"}}
+
+var gebaeudeTable = importTable{"gebaeude", []string{"adrcd", "subcd"},
+	[]string{"adrcd", "subcd", "hauptadresse", "hausnrgebaeudebez", "rw", "hw", "epsg", "latlong"},
+	[]string{"latlong"}}
+
+// searchVector is the text the search column is built from, see memStore.tokens
+const searchVector = `to_tsvector('%s', concat_ws(' ', strassenname, ortsname, gemeindename, plz,
+hausnrzahl1, hausnrzahl1 || hausnrbuchstabe1, hausnrtext, hofname))`
+
+// deriveStagingSQL fills the derived columns in the configured text search
+// configuration
+func deriveStagingSQL() string {
+	return `update adresse_import set latlong_g = latlong::geography;
+update addritems_import set search = ` + fmt.Sprintf(searchVector, textSearchConfig)
+}
+
+const indexStagingSQL = `create index on adresse_import using gist (latlong_g);
+create index on addritems_import using gin (search);
+create index on addritems_import (gkz);
+create index on addritems_import (plz);
+create index on addritems_import (okz);
+create index on addritems_import (skz);
+create index on addritems_import (substr(gkz, 1, 3));
+analyze adresse_import;
+analyze addritems_import;
+analyze gebaeude_import`
+
+// trigramIndexSQL creates the trigram indexes of the fuzzy search tier, one
+// on the expression fuzzyNames, which the free-text query is compared to
+var trigramIndexSQL = `create index on addritems_import using gin (strassenname gin_trgm_ops);
+create index on addritems_import using gin (ortsname gin_trgm_ops);
+create index on addritems_import using gin (gemeindename gin_trgm_ops);
+create index on addritems_import using gin (` + strings.Replace(fuzzyNames, "addritems.", "", -1) + ` gin_trgm_ops)`
+
+// swapSQL replaces the live tables by the staging tables. Renaming takes an
+// exclusive lock, so queries see either the old or the new release.
+const swapSQL = `alter table if exists adresse rename to adresse_replaced;
+alter table if exists addritems rename to addritems_replaced;
+alter table if exists gebaeude rename to gebaeude_replaced;
+alter table adresse_import rename to adresse;
+alter table addritems_import rename to addritems;
+alter table gebaeude_import rename to gebaeude;
+drop table if exists adresse_replaced, addritems_replaced, gebaeude_replaced`
+
+const dropStagingSQL = `drop table adresse_import, addritems_import, gebaeude_import`
+
+// importRunsSQL creates the tables recording every import and the addresses
+// changed by incremental imports
+const importRunsSQL = `create table if not exists import_runs (
+	id serial primary key,
+	mode text not null,
+	stichtag date not null,
+	started timestamptz not null,
+	finished timestamptz not null default clock_timestamp(),
+	addresses integer not null,
+	addresses_inserted integer not null,
+	addresses_updated integer not null,
+	addresses_retired integer not null,
+	buildings integer not null,
+	buildings_inserted integer not null,
+	buildings_updated integer not null,
+	buildings_retired integer not null
+);
+create table if not exists address_changes (
+	seq bigserial primary key,
+	run_id integer not null references import_runs,
+	stichtag date not null,
+	adrcd text not null,
+	change text not null
+)`
+
+const insertImportRunSQL = `insert into import_runs (mode, stichtag, started, addresses, addresses_inserted, addresses_updated, addresses_retired,
+buildings, buildings_inserted, buildings_updated, buildings_retired)
+values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+returning id, finished`
+
+// changedAddresses classifies the addresses changed by the release of the
+// Stichtag given by the SQL parameter stichtag, in adresse or addritems: an
+// address without a version before the release was added, one without a
+// version after the release was removed.
+func changedAddresses(stichtag string) string {
+	return `select adrcd,
+CASE WHEN NOT bool_or(valid_from < ` + stichtag + `) THEN 'added'
+WHEN NOT bool_or(valid_to IS NULL OR valid_to > ` + stichtag + `) THEN 'removed'
+ELSE 'modified' END as change
+from (select adrcd, valid_from, valid_to from adresse where valid_from = ` + stichtag + ` or valid_to = ` + stichtag + `
+union all
+select adrcd, valid_from, valid_to from addritems where valid_from = ` + stichtag + ` or valid_to = ` + stichtag + `) v
+group by adrcd`
+}
+
+// insertChangesSQL records the addresses changed by the import run $1 of the
+// release of Stichtag $2
+var insertChangesSQL = `insert into address_changes (run_id, stichtag, adrcd, change)
+select CAST($1 AS integer), CAST($2 AS date), adrcd, change
+from (` + changedAddresses("CAST($2 AS date)") + `) c
+order by adrcd`
+
+// countChangesSQL counts the addresses added, modified and removed by the
+// release of Stichtag $1
+var countChangesSQL = `select count(*) filter (where change = 'added'), count(*) filter (where change = 'modified'), count(*) filter (where change = 'removed')
+from (` + changedAddresses("CAST($1 AS date)") + `) c`
+
+// changes counts the records an import inserted, updated and retired
+type changes struct {
+	inserted, updated, retired int64
+}
+
+// importStats counts the records of an import
+type importStats struct {
+	addresses, withoutCoordinates, buildings int
+	addressChanges, buildingChanges          changes
+}
+
+// importCommand runs the import subcommand: bevaddressapi import [-incremental] [release.zip]
+func importCommand(args []string) {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	incremental := flags.Bool("incremental", false, "apply the differences to the loaded release instead of replacing it")
+	stichtag := flags.String("stichtag", "", "reference date of the release, YYYY-MM-DD; defaults to the date of the files in the release")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: bevaddressapi import [-incremental] [-stichtag YYYY-MM-DD] [release.zip]")
+		fmt.Fprintln(os.Stderr, "loads the BEV Adressregister release into the database given by -database-url; the release defaults to -bev-zip")
+		fmt.Fprintln(os.Stderr, "the history of the addresses is kept, unless a release not newer than the loaded one is imported in full")
+		flags.PrintDefaults()
+	}
+	cfg, err := loadConfig(flags, args)
+	if err != nil {
+		fatal("invalid configuration: " + err.Error())
+	}
+	configure(cfg)
+
+	name := flags.Arg(0)
+	if name == "" {
+		name = cfg.BEVZip
+	}
+	if name == "" {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	var date time.Time
+	if *stichtag != "" {
+		if date, err = time.Parse("2006-01-02", *stichtag); err != nil {
+			fatal("error when parsing parameter stichtag: " + err.Error())
+		}
+	}
+
+	db, err := getDatabaseConnection(cfg, 0)
+	if err != nil {
+		fatal(err.Error())
+	}
+	defer db.Close()
+
+	start := time.Now()
+	info("importing " + name)
+	stats, err := importRelease(db, name, date, *incremental)
+	if err != nil {
+		fatal("import failed: " + err.Error())
+	}
+	info("imported %d addresses, %d without coordinates, and %d buildings in %s", stats.addresses, stats.withoutCoordinates, stats.buildings, time.Since(start).Round(time.Second))
+	if *incremental {
+		info("addresses: %d inserted, %d updated, %d retired", stats.addressChanges.inserted, stats.addressChanges.updated, stats.addressChanges.retired)
+		info("buildings: %d inserted, %d updated, %d retired", stats.buildingChanges.inserted, stats.buildingChanges.updated, stats.buildingChanges.retired)
+	}
+}
+
+// importRelease loads the release ZIP name into the database, either
+// replacing the loaded release, keeping its history when the release is
+// newer, or, when incremental is set, applying the differences to it. The whole import runs in one transaction: when it
+// fails, the live tables are left untouched. stichtag defaults to the date
+// of the release files when zero.
+func importRelease(db *sql.DB, name string, stichtag time.Time, incremental bool) (*importStats, error) {
+	started := time.Now()
+
+	rel, err := bevdata.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer rel.Close()
+	if stichtag.IsZero() {
+		stichtag = rel.Stichtag()
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.Exec(importRunsSQL); err != nil {
+		return nil, err
+	}
+	var last sql.NullString
+	if err = tx.QueryRow(`select to_char(max(stichtag), 'YYYY-MM-DD') from import_runs`).Scan(&last); err != nil {
+		return nil, err
+	}
+	if incremental {
+		switch {
+		case !last.Valid:
+			return nil, errors.New("an incremental import needs a release loaded by a previous import")
+		case last.String >= stichtag.Format("2006-01-02"):
+			return nil, errors.New("the loaded release of " + last.String + " is not older than the release to import")
+		}
+	}
+
+	if _, err = tx.Exec(createStagingSQL); err != nil {
+		return nil, errors.New("creating the staging tables failed: " + err.Error())
+	}
+
+	stats, err := copyRelease(tx, rel, stichtag)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = tx.Exec(deriveStagingSQL()); err != nil {
+		return nil, errors.New("building search columns failed: " + err.Error())
+	}
+
+	mode := "full"
+	if incremental {
+		mode = "incremental"
+		for _, t := range []*importTable{&adresseTable, &addritemsTable} {
+			if _, err = applyChanges(tx, t, stichtag); err != nil {
+				return nil, err
+			}
+		}
+		if stats.buildingChanges, err = applyChanges(tx, &gebaeudeTable, stichtag); err != nil {
+			return nil, err
+		}
+		// an address changes in adresse, its coordinates, or in addritems,
+		// its street and house number
+		c := &stats.addressChanges
+		if err = tx.QueryRow(countChangesSQL, stichtag.Format("2006-01-02")).Scan(&c.inserted, &c.updated, &c.retired); err != nil {
+			return nil, errors.New("counting the changed addresses failed: " + err.Error())
+		}
+		if _, err = tx.Exec(dropStagingSQL); err != nil {
+			return nil, err
+		}
+	} else {
+		stats.addressChanges.inserted, stats.buildingChanges.inserted = int64(stats.addresses), int64(stats.buildings)
+		if last.Valid && last.String < stichtag.Format("2006-01-02") {
+			for _, t := range []*importTable{&adresseTable, &addritemsTable, &gebaeudeTable} {
+				if err = carryHistory(tx, t, stichtag); err != nil {
+					return nil, err
+				}
+			}
+		} else if last.Valid {
+			info("the loaded release of " + last.String + " is not older than the release to import, the history of the register starts anew")
+		}
+		if err = indexStaging(tx); err != nil {
+			return nil, err
+		}
+		if _, err = tx.Exec(swapSQL); err != nil {
+			return nil, errors.New("replacing the tables failed: " + err.Error())
+		}
+	}
+
+	notice := releaseNotice{Mode: mode, Stichtag: stichtag.Format("2006-01-02")}
+	err = tx.QueryRow(insertImportRunSQL, notice.Mode, notice.Stichtag, started,
+		stats.addresses, stats.addressChanges.inserted, stats.addressChanges.updated, stats.addressChanges.retired,
+		stats.buildings, stats.buildingChanges.inserted, stats.buildingChanges.updated, stats.buildingChanges.retired).Scan(&notice.Run, &notice.Loaded)
+	if err != nil {
+		return nil, errors.New("recording the import failed: " + err.Error())
+	}
+	if incremental {
+		if err = recordChanges(tx, &notice); err != nil {
+			return nil, err
+		}
+	}
+
+	// subscribers of the change feed are notified when the transaction commits
+	payload, _ := json.Marshal(notice)
+	if _, err = tx.Exec(`select pg_notify($1, $2)`, releaseChannel, string(payload)); err != nil {
+		return nil, err
+	}
+	return stats, tx.Commit()
+}
+
+// recordChanges records the addresses changed by the import run of n for the
+// change feed
+func recordChanges(tx *sql.Tx, n *releaseNotice) error {
+	if err := tx.QueryRow(`select COALESCE(max(seq), 0) from address_changes`).Scan(&n.After); err != nil {
+		return err
+	}
+	res, err := tx.Exec(insertChangesSQL, n.Run, n.Stichtag)
+	if err != nil {
+		return errors.New("recording the changes failed: " + err.Error())
+	}
+	n.Changes, err = res.RowsAffected()
+	return err
+}
+
+// indexStaging creates the indexes of the staging tables before they replace
+// the live tables
+func indexStaging(tx *sql.Tx) error {
+	if _, err := tx.Exec(indexStagingSQL); err != nil {
+		return errors.New("building indexes failed: " + err.Error())
+	}
+
+	var trgm bool
+	if err := tx.QueryRow(trigramSQL).Scan(&trgm); err != nil {
+		return err
+	}
+	if !trgm {
+		info("extension pg_trgm is not installed, the fuzzy search tier will not work")
+		return nil
+	}
+	if _, err := tx.Exec(trigramIndexSQL); err != nil {
+		return errors.New("building trigram indexes failed: " + err.Error())
+	}
+	return nil
+}
+
+// carryHistory keeps the versions of the live table t across a full import
+// of the release of stichtag: records unchanged since the loaded release keep
+// the start of their current version, and the ended versions as well as the
+// current versions of changed or missing records, ended at stichtag, are
+// copied into the staging table
+func carryHistory(tx *sql.Tx, t *importTable, stichtag time.Time) error {
+	for _, stmt := range []string{t.keepSQL(), t.carrySQL()} {
+		if _, err := tx.Exec(stmt, stichtag.Format("2006-01-02")); err != nil {
+			return errors.New("keeping the versions of " + t.name + " failed: " + err.Error())
+		}
+	}
+	return nil
+}
+
+// applyChanges brings the live table t to the state of its staging table as
+// of stichtag: the versions of records missing from the release are retired,
+// the versions of records whose columns differ are replaced by a new version
+// and new records are inserted
+func applyChanges(tx *sql.Tx, t *importTable, stichtag time.Time) (changes, error) {
+	var c changes
+	for _, stmt := range []struct {
+		sql   string
+		count *int64
+	}{
+		{t.retireSQL(), &c.retired},
+		{t.supersedeSQL(), &c.updated},
+		{t.insertSQL(), &c.inserted},
+	} {
+		res, err := tx.Exec(stmt.sql, stichtag.Format("2006-01-02"))
+		if err != nil {
+			return c, errors.New("updating " + t.name + " failed: " + err.Error())
+		}
+		if *stmt.count, err = res.RowsAffected(); err != nil {
+			return c, err
+		}
+	}
+	// new versions of updated records are inserted along with new records
+	c.inserted -= c.updated
+	return c, nil
+}
+
+// all returns the columns copied from the release followed by the derived
+// columns
+func (t *importTable) all() []string {
+	columns := append([]string{}, t.columns...)
+	for _, column := range t.derived {
+		if !contains(t.columns, column) {
+			columns = append(columns, column)
+		}
+	}
+	return columns
+}
+
+// compared returns the columns which tell whether a record changed
+func (t *importTable) compared() []string {
+	var columns []string
+	for _, column := range t.columns {
+		if !contains(t.key, column) && !contains(t.derived, column) {
+			columns = append(columns, column)
+		}
+	}
+	return columns
+}
+
+// keyMatch joins the live table, aliased t, and the staging table, aliased i
+func (t *importTable) keyMatch() string {
+	var conditions []string
+	for _, column := range t.key {
+		conditions = append(conditions, "t."+column+" = i."+column)
+	}
+	return strings.Join(conditions, " and ")
+}
+
+// retireSQL ends the current version of the records missing from the release
+// at the Stichtag $1
+func (t *importTable) retireSQL() string {
+	return "update " + t.name + " t set valid_to = $1 where t.valid_to is null" +
+		" and not exists (select 1 from " + t.name + "_import i where " + t.keyMatch() + ")"
+}
+
+// supersedeSQL ends the current version of the records which changed at the
+// Stichtag $1
+func (t *importTable) supersedeSQL() string {
+	return "update " + t.name + " t set valid_to = $1 from " + t.name + "_import i where " + t.keyMatch() +
+		" and t.valid_to is null" +
+		" and (" + qualify("t", t.compared()) + ") is distinct from (" + qualify("i", t.compared()) + ")"
+}
+
+// insertSQL inserts the records of the release which have no current version,
+// the new ones and those whose version was ended by supersedeSQL, valid from
+// the Stichtag $1
+func (t *importTable) insertSQL() string {
+	columns := t.all()
+	return "insert into " + t.name + " (" + strings.Join(columns, ", ") + ", valid_from) select " + qualify("i", columns) + ", CAST($1 AS date)" +
+		" from " + t.name + "_import i where not exists (select 1 from " + t.name + " t where " + t.keyMatch() + " and t.valid_to is null)"
+}
+
+// keepSQL makes the records of the staging table which are unchanged since
+// the current version of the live table start at that version
+func (t *importTable) keepSQL() string {
+	return "update " + t.name + "_import i set valid_from = t.valid_from from " + t.name + " t where " + t.keyMatch() +
+		" and t.valid_to is null" +
+		" and (" + qualify("t", t.compared()) + ") is not distinct from (" + qualify("i", t.compared()) + ")"
+}
+
+// carrySQL copies the versions of the live table which were not kept by
+// keepSQL into the staging table, ending the current ones at the Stichtag $1
+func (t *importTable) carrySQL() string {
+	columns := t.all()
+	return "insert into " + t.name + "_import (" + strings.Join(columns, ", ") + ", valid_from, valid_to) select " + qualify("t", columns) +
+		", t.valid_from, COALESCE(t.valid_to, CAST($1 AS date)) from " + t.name + " t" +
+		" where t.valid_to is not null or not exists (select 1 from " + t.name + "_import i where " + t.keyMatch() + " and i.valid_from = t.valid_from)"
+}
+
+// qualify prefixes columns with the table alias and joins them
+func qualify(alias string, columns []string) string {
+	qualified := make([]string, len(columns))
+	for i, column := range columns {
+		qualified[i] = alias + "." + column
+	}
+	return strings.Join(qualified, ", ")
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+// copyRelease copies the addresses and buildings of rel into the staging
+// tables as versions valid from stichtag
+func copyRelease(tx *sql.Tx, rel *bevdata.Release, stichtag time.Time) (*importStats, error) {
+	gemeinden := make(map[string]string)
+	err := rel.Gemeinden(func(g bevdata.Gemeinde) error {
+		gemeinden[g.GKZ] = g.Gemeindename
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	ortschaften := make(map[string]string)
+	err = rel.Ortschaften(func(o bevdata.Ortschaft) error {
+		ortschaften[o.GKZ+"/"+o.OKZ] = o.Ortsname
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	strassen := make(map[string]string)
+	err = rel.Strassen(func(s bevdata.Strasse) error {
+		strassen[s.SKZ] = s.Strassenname
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &importStats{}
+	err = copyTable(tx, &adresseTable, stichtag, func(row func(...interface{}) error) error {
+		return rel.Adressen(func(a bevdata.Adresse) error {
+			if len(a.GKZ) != 5 {
+				return errors.New("address " + a.ADRCD + ": malformed GKZ " + a.GKZ)
+			}
+			stats.addresses++
+
+			rw, hw, epsg, latlong := point(a.RW, a.HW, a.EPSG)
+			if latlong == nil {
+				stats.withoutCoordinates++
+			}
+			return row(a.ADRCD, a.GKZ, nullString(a.OKZ), nullString(a.SKZ), nullString(a.PLZ), rw, hw, epsg, latlong)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = copyTable(tx, &addritemsTable, stichtag, func(row func(...interface{}) error) error {
+		return rel.Adressen(func(a bevdata.Adresse) error {
+			hausnrzahl1, err := nullInt(a.HausnrZahl1)
+			if err != nil {
+				return errors.New("address " + a.ADRCD + ": malformed HAUSNRZAHL1: " + err.Error())
+			}
+			hausnrzahl2, err := nullInt(a.HausnrZahl2)
+			if err != nil {
+				return errors.New("address " + a.ADRCD + ": malformed HAUSNRZAHL2: " + err.Error())
+			}
+			return row(a.ADRCD, a.GKZ[:1], a.GKZ, nullString(a.OKZ), nullString(a.SKZ), nullString(a.PLZ),
+				nullString(gemeinden[a.GKZ]), nullString(ortschaften[a.GKZ+"/"+a.OKZ]), nullString(strassen[a.SKZ]),
+				hausnrzahl1, nullString(a.HausnrBuchstabe1), nullString(a.HausnrVerbindung1), hausnrzahl2, nullString(a.HausnrBuchstabe2),
+				nullString(a.HausnrBereich), nullString(a.HausnrText), nullString(a.Hofname))
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = copyTable(tx, &gebaeudeTable, stichtag, func(row func(...interface{}) error) error {
+		return rel.Gebaeude(func(g bevdata.Gebaeude) error {
+			stats.buildings++
+			rw, hw, epsg, latlong := point(g.RW, g.HW, g.EPSG)
+			return row(g.ADRCD, g.SUBCD, nullString(g.Hauptadresse), nullString(g.HausnrGebaeudeBez), rw, hw, epsg, latlong)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// copyTable copies the rows passed to row by rows into the staging table of
+// t, valid from stichtag. A connection runs one COPY at a time, so every
+// table takes a pass over the release.
+func copyTable(tx *sql.Tx, t *importTable, stichtag time.Time, rows func(row func(...interface{}) error) error) error {
+	table := t.name + "_import"
+	stmt, err := tx.Prepare(pq.CopyIn(table, append(t.columns, "valid_from")...))
+	if err != nil {
+		return errors.New("copying into " + table + " failed: " + err.Error())
+	}
+	defer stmt.Close()
+
+	var n int
+	validFrom := stichtag.Format("2006-01-02")
+	err = rows(func(args ...interface{}) error {
+		if _, err := stmt.Exec(append(args, validFrom)...); err != nil {
+			return err
+		}
+		if n++; n%500000 == 0 {
+			info("copied %d rows into %s", n, table)
+		}
+		return nil
+	})
+	if err == nil {
+		// flush the buffered rows
+		_, err = stmt.Exec()
+	}
+	if err != nil {
+		return errors.New("copying into " + table + " failed: " + err.Error())
+	}
+	return nil
+}
+
+// point returns the columns rw, hw, epsg and latlong of an address or
+// building, all nil when it has no coordinates
+func point(rw, hw float64, epsg int) (interface{}, interface{}, interface{}, interface{}) {
+	if epsg == 0 {
+		return nil, nil, nil, nil
+	}
+	lat, lon, err := mgi.ToWGS84(epsg, rw, hw)
+	if err != nil {
+		return nil, nil, nil, nil
+	}
+	return rw, hw, epsg, "SRID=4326;POINT(" + strconv.FormatFloat(lon, 'f', -1, 64) + " " + strconv.FormatFloat(lat, 'f', -1, 64) + ")"
+}
+
+// nullString returns nil for an empty string, which COPY stores as NULL
+func nullString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// nullInt parses a number of the release, nil when s is empty
+func nullInt(s string) (interface{}, error) {
+	if s == "" {
+		return nil, nil
+	}
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		return nil, err
+	}
+	return i, nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testRelease2 is the release following testRelease: address 2 changed its
+// house number letter, address 3 was retired and address 4 is new
+func testRelease2() map[string]string {
+	files := make(map[string]string)
+	for name, content := range testRelease {
+		files[name] = content
+	}
+	files["Adresse.csv"] = strings.Replace(files["Adresse.csv"], "2;90101;17224;1010;001;;;3;a;", "2;90101;17224;1010;001;;;3;b;", 1)
+	files["Adresse.csv"] = strings.Replace(files["Adresse.csv"], "3;70101;05001;6020;002;;;18;;;;;;;;-4600;237450;31254;;\n",
+		"4;70101;05001;6020;002;;;20;;;;;;;;-4590;237460;31254;;\n", 1)
+	return files
+}
+
+// importTestReleases imports testRelease as of 2026-04-01 in full and then
+// testRelease2 as of 2026-07-01, incrementally unless full is set, and
+// returns the statistics of the latter
+func importTestReleases(t *testing.T, full bool) (*postgisStore, *importStats) {
+	dburl := os.Getenv(testDatabaseEnv)
+	if dburl == "" {
+		t.Skip(testDatabaseEnv + " not set")
+	}
+	db, err := sql.Open("postgres", dburl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	dir := t.TempDir()
+	first := writeTestRelease(t, dir, testRelease)
+	if _, err := importRelease(db, first, time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC), false); err != nil {
+		t.Fatal(err)
+	}
+	second := writeTestRelease(t, t.TempDir(), testRelease2())
+	stats, err := importRelease(db, second, time.Date(2026, 7, 1, 0, 0, 0, 0, time.UTC), !full)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &postgisStore{DB: db}, stats
+}
+
+// checkHistory checks the versions of the addresses after importTestReleases
+func checkHistory(t *testing.T, pg *postgisStore) {
+	t.Helper()
+	ctx := context.Background()
+	for _, tt := range []struct {
+		adrcd string
+		want  []string // house number, valid from and valid to of the versions
+	}{
+		{"1", []string{"2 2026-04-01 -"}},
+		{"2", []string{"3a 2026-04-01 2026-07-01", "3b 2026-07-01 -"}},
+		{"3", []string{"18 2026-04-01 2026-07-01"}},
+		{"4", []string{"20 2026-07-01 -"}},
+	} {
+		versions, err := pg.History(ctx, tt.adrcd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, v := range versions {
+			to := "-"
+			if v.ValidTo != nil {
+				to = *v.ValidTo
+			}
+			got = append(got, v.HausnrAnzeige+" "+v.ValidFrom+" "+to)
+		}
+		if strings.Join(got, ", ") != strings.Join(tt.want, ", ") {
+			t.Errorf("History(%s) = %v, want %v", tt.adrcd, got, tt.want)
+		}
+	}
+
+	if d, err := pg.Detail(ctx, "2", "2026-05-01"); err != nil || d == nil || d.HausnrAnzeige != "3a" {
+		t.Errorf("Detail(2) as of 2026-05-01 = %+v, %v, want 3a", d, err)
+	}
+	if d, err := pg.Detail(ctx, "3", ""); err != nil || d != nil {
+		t.Errorf("Detail(3) = %+v, %v, want nil", d, err)
+	}
+}
+
+func TestFullImportKeepsHistory(t *testing.T) {
+	pg, _ := importTestReleases(t, true)
+	checkHistory(t, pg)
+}
+
+func TestIncrementalImport(t *testing.T) {
+	pg, stats := importTestReleases(t, false)
+	checkHistory(t, pg)
+
+	if c := stats.addressChanges; c != (changes{inserted: 1, updated: 1, retired: 1}) {
+		t.Errorf("address changes = %+v, want 1 inserted, 1 updated, 1 retired", c)
+	}
+
+	con := &connection{store: pg}
+	w := serve(con.changeFeed, "GET", "/api/changes?since=2026-04-01", "", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", w.Code, w.Body)
+	}
+	var page changePage
+	if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, c := range page.Changes {
+		if c.Stichtag != "2026-07-01" || (c.Before == nil) != (c.Type == "added") || (c.After == nil) != (c.Type == "removed") {
+			t.Errorf("change %+v", c)
+		}
+		got = append(got, c.ADRCD+" "+c.Type)
+	}
+	if want := "2 modified, 3 removed, 4 added"; strings.Join(got, ", ") != want {
+		t.Errorf("changes = %v, want %s", got, want)
+	}
+	if page.Next != nil {
+		t.Errorf("next page %s, want none", *page.Next)
+	}
+}
@@ -0,0 +1,902 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/the42/bevaddressapi/addrparse"
+	"github.com/the42/bevaddressapi/bevdata"
+	"github.com/the42/bevaddressapi/mgi"
+)
+
+const gridSize = 100 // cells of the spatial grid per degree latitude and longitude
+
+// memStore is the AddressStore holding a BEV Adressregister release in
+// memory. It answers like the PostGIS backend, but without PostgreSQL.
+type memStore struct {
+	addresses []memAddress
+	byADRCD   map[string]int32
+	names     []memName // street, locality and municipality names shared by the addresses
+
+	postings   map[string][]int32 // addresses by token
+	vocabulary []string           // sorted tokens of postings
+	grid       map[gridCell][]int32
+
+	buildings map[int32][]memBuilding
+
+	stichtag, loaded time.Time
+}
+
+type memName struct {
+	name   string
+	tokens []string
+}
+
+type memAddress struct {
+	adrcd, gkz, okz, skz, plz      string
+	street, locality, municipality int32 // index into names
+
+	hausnrzahl1, hausnrbuchstabe1, hausnrverbindung1, hausnrzahl2, hausnrbuchstabe2 string
+	hausnrbereich, hausnrtext, hofname                                              string
+
+	lat, lon float64
+	hasCoord bool
+	rw, hw   float64 // coordinates as published, in Gauß-Krüger projection epsg
+	epsg     int
+}
+
+type memBuilding struct {
+	subcd, hausnrgebaeudebez string
+	hauptadresse             bool
+	rw, hw                   float64
+	epsg                     int
+}
+
+type gridCell struct {
+	lat, lon int32
+}
+
+func cellOf(lat, lon float64) gridCell {
+	return gridCell{int32(math.Floor(lat * gridSize)), int32(math.Floor(lon * gridSize))}
+}
+
+// loadMemStore reads the BEV Adressregister release ZIP at name
+func loadMemStore(name string) (*memStore, error) {
+	rel, err := bevdata.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer rel.Close()
+
+	m := &memStore{
+		stichtag:  rel.Stichtag(),
+		byADRCD:   make(map[string]int32),
+		postings:  make(map[string][]int32),
+		grid:      make(map[gridCell][]int32),
+		buildings: make(map[int32][]memBuilding),
+	}
+	nameIdx := make(map[string]int32)
+	intern := func(name string) int32 {
+		if i, ok := nameIdx[name]; ok {
+			return i
+		}
+		i := int32(len(m.names))
+		m.names = append(m.names, memName{name: name, tokens: tokenize(name)})
+		nameIdx[name] = i
+		return i
+	}
+	intern("") // index 0 is the missing name
+
+	gemeinden := make(map[string]string)
+	err = rel.Gemeinden(func(g bevdata.Gemeinde) error {
+		gemeinden[g.GKZ] = g.Gemeindename
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ortschaften := make(map[string]string)
+	err = rel.Ortschaften(func(o bevdata.Ortschaft) error {
+		ortschaften[o.GKZ+"/"+o.OKZ] = o.Ortsname
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	strassen := make(map[string]string)
+	err = rel.Strassen(func(s bevdata.Strasse) error {
+		strassen[s.SKZ] = s.Strassenname
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = rel.Adressen(func(a bevdata.Adresse) error {
+		addr := memAddress{
+			adrcd:             a.ADRCD,
+			gkz:               a.GKZ,
+			okz:               a.OKZ,
+			skz:               a.SKZ,
+			plz:               a.PLZ,
+			street:            intern(strassen[a.SKZ]),
+			locality:          intern(ortschaften[a.GKZ+"/"+a.OKZ]),
+			municipality:      intern(gemeinden[a.GKZ]),
+			hausnrzahl1:       a.HausnrZahl1,
+			hausnrbuchstabe1:  a.HausnrBuchstabe1,
+			hausnrverbindung1: a.HausnrVerbindung1,
+			hausnrzahl2:       a.HausnrZahl2,
+			hausnrbuchstabe2:  a.HausnrBuchstabe2,
+			hausnrbereich:     a.HausnrBereich,
+			hausnrtext:        a.HausnrText,
+			hofname:           a.Hofname,
+			rw:                a.RW,
+			hw:                a.HW,
+			epsg:              a.EPSG,
+		}
+		addr.lat, addr.lon, addr.hasCoord = a.LatLon()
+
+		id := int32(len(m.addresses))
+		m.addresses = append(m.addresses, addr)
+		m.byADRCD[addr.adrcd] = id
+		for _, token := range m.tokens(&m.addresses[id]) {
+			postings := m.postings[token]
+			if len(postings) == 0 || postings[len(postings)-1] != id {
+				m.postings[token] = append(postings, id)
+			}
+		}
+		if addr.hasCoord {
+			cell := cellOf(addr.lat, addr.lon)
+			m.grid[cell] = append(m.grid[cell], id)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = rel.Gebaeude(func(g bevdata.Gebaeude) error {
+		if id, ok := m.byADRCD[g.ADRCD]; ok {
+			m.buildings[id] = append(m.buildings[id], memBuilding{
+				subcd:             g.SUBCD,
+				hausnrgebaeudebez: g.HausnrGebaeudeBez,
+				hauptadresse:      g.Hauptadresse == "1",
+				rw:                g.RW,
+				hw:                g.HW,
+				epsg:              g.EPSG,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	m.vocabulary = make([]string, 0, len(m.postings))
+	for token := range m.postings {
+		m.vocabulary = append(m.vocabulary, token)
+	}
+	sort.Strings(m.vocabulary)
+	m.loaded = time.Now()
+	return m, nil
+}
+
+// tokens returns the tokens an address is found by, the equivalent of the
+// search column of the database
+func (m *memStore) tokens(a *memAddress) []string {
+	var tokens []string
+	tokens = append(tokens, m.names[a.street].tokens...)
+	tokens = append(tokens, m.names[a.locality].tokens...)
+	tokens = append(tokens, m.names[a.municipality].tokens...)
+	tokens = append(tokens, tokenize(a.plz+" "+a.hausnrzahl1+a.hausnrbuchstabe1+" "+a.hausnrzahl1+" "+a.hofname)...)
+	return tokens
+}
+
+// tokenize splits s into lower case words with umlauts and ß folded, after
+// expanding abbreviated street types
+func tokenize(s string) []string {
+	s = strings.ToLower(addrparse.Expand(s))
+	s = strings.NewReplacer("ä", "a", "ö", "o", "ü", "u", "ß", "ss").Replace(s)
+	return strings.FieldsFunc(s, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) })
+}
+
+// memTerm is a word of a query. It matches a token equal to word, starting
+// with word when prefix is set, or any of the alternatives of a fuzzy search.
+type memTerm struct {
+	word   string
+	prefix bool
+	alts   map[string]float64 // token to similarity
+}
+
+// similarity returns how well token matches t, 0 if it does not match
+func (t *memTerm) similarity(token string) float64 {
+	if t.alts != nil {
+		return t.alts[token]
+	}
+	if token == t.word || t.prefix && strings.HasPrefix(token, t.word) {
+		return 1
+	}
+	return 0
+}
+
+// terms splits a query into terms. With autocomplete, the last word is
+// matched as prefix.
+func (m *memStore) terms(s string, autocomplete, fuzzy bool) []*memTerm {
+	var terms []*memTerm
+	words := tokenize(s)
+	for i, w := range words {
+		t := &memTerm{word: w, prefix: autocomplete && i == len(words)-1}
+		if fuzzy && len(m.candidates(t)) == 0 {
+			t.alts = m.similar(w)
+		}
+		terms = append(terms, t)
+	}
+	return terms
+}
+
+// similar returns the tokens within a small edit distance to word
+func (m *memStore) similar(word string) map[string]float64 {
+	n := len([]rune(word))
+	maxDist := 1
+	if n > 5 {
+		maxDist = 2
+	}
+
+	alts := make(map[string]float64)
+	for _, token := range m.vocabulary {
+		if d := levenshtein(word, token, maxDist); d <= maxDist {
+			alts[token] = 1 - float64(d)/float64(n+1)
+		}
+	}
+	return alts
+}
+
+// levenshtein returns the edit distance of a and b, or max+1 when it exceeds
+// max
+func levenshtein(a, b string, max int) int {
+	ra, rb := []rune(a), []rune(b)
+	if d := len(ra) - len(rb); d > max || -d > max {
+		return max + 1
+	}
+
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		rowMin := curr[0]
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min3(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+			if curr[j] < rowMin {
+				rowMin = curr[j]
+			}
+		}
+		if rowMin > max {
+			return max + 1
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+// candidates returns the postings of the tokens matching t
+func (m *memStore) candidates(t *memTerm) [][]int32 {
+	var lists [][]int32
+	switch {
+	case t.alts != nil:
+		for token := range t.alts {
+			lists = append(lists, m.postings[token])
+		}
+	case t.prefix:
+		for i := sort.SearchStrings(m.vocabulary, t.word); i < len(m.vocabulary) && strings.HasPrefix(m.vocabulary[i], t.word); i++ {
+			lists = append(lists, m.postings[m.vocabulary[i]])
+		}
+	default:
+		if postings, ok := m.postings[t.word]; ok {
+			lists = append(lists, postings)
+		}
+	}
+	return lists
+}
+
+// matchTerms returns the product of the best similarities of every term to
+// one of tokens, 0 if a term does not match at all
+func matchTerms(terms []*memTerm, tokens []string) float64 {
+	score := 1.0
+	for _, t := range terms {
+		best := 0.0
+		for _, token := range tokens {
+			if s := t.similarity(token); s > best {
+				best = s
+			}
+		}
+		if best == 0 {
+			return 0
+		}
+		score *= best
+	}
+	return score
+}
+
+// memQuery is a search prepared from ftsParams
+type memQuery struct {
+	q, street, city, locality              []*memTerm
+	number, letter, number2, letter2, name string
+	lat, lon                               float64
+	geo                                    bool
+	fuzzy                                  bool // the fuzzy search tier
+}
+
+// Search runs the search described by p with the same tiers as the PostGIS
+// backend: the components of a free-text query first, then the query as
+// typed and finally the fuzzy tier
+func (m *memStore) Search(ctx context.Context, p *ftsParams) ([]Address, error) {
+	if m.before(p.asof) {
+		return nil, nil
+	}
+	pp := p.parsed()
+
+	if p.fuzzy != fuzzyAlways {
+		if pp != nil {
+			if addresses, err := m.search(ctx, pp, false); err != nil || len(addresses) > 0 {
+				return addresses, err
+			}
+		}
+		addresses, err := m.search(ctx, p, false)
+		if err != nil || len(addresses) > 0 || p.fuzzy == fuzzyNever {
+			return addresses, err
+		}
+	}
+
+	if pp != nil {
+		p = pp
+	}
+	return m.search(ctx, p, true)
+}
+
+func (m *memStore) search(ctx context.Context, p *ftsParams, fuzzy bool) ([]Address, error) {
+	mq := memQuery{
+		q:        m.terms(p.q, p.autocomplete, fuzzy),
+		street:   m.terms(p.street, p.autocomplete, fuzzy),
+		city:     m.terms(p.city, p.autocomplete, fuzzy),
+		locality: m.terms(p.locality, p.autocomplete, fuzzy),
+		fuzzy:    fuzzy,
+	}
+	if len(mq.q)+len(mq.street)+len(mq.city)+len(mq.locality) == 0 && p.housenumber == "" {
+		return nil, nil
+	}
+	if p.housenumber != "" {
+		if hnr, ok := addrparse.ParseHouseNumber(p.housenumber); ok {
+			mq.number, mq.letter, mq.number2, mq.letter2 = hnr.HouseNumber, hnr.Letter, hnr.HouseNumberTo, hnr.LetterTo
+		} else {
+			mq.name = strings.ToLower(p.housenumber)
+		}
+	}
+	if p.lat != "" {
+		var err error
+		if mq.lat, mq.lon, err = parseLatLon(p.lat, p.lon); err != nil {
+			return nil, err
+		}
+		mq.geo = true
+	}
+
+	// iterate the shortest list of candidates and verify all conditions.
+	// Every term and the reference point restrict the result, so there is
+	// none when one of them has no candidates.
+	var lists [][]int32
+	chosen := false
+	choose := func(l [][]int32) bool {
+		if !chosen || total(l) < total(lists) {
+			lists, chosen = l, true
+		}
+		return total(l) > 0
+	}
+	for _, terms := range [][]*memTerm{mq.q, mq.street, mq.city, mq.locality} {
+		for _, t := range terms {
+			if !choose(m.candidates(t)) {
+				return nil, nil
+			}
+		}
+	}
+	if mq.geo && !choose(m.nearby(mq.lat, mq.lon, nearbymeters)) {
+		return nil, nil
+	}
+	if !chosen {
+		lists = [][]int32{nil}
+		for i := range m.addresses {
+			lists[0] = append(lists[0], int32(i))
+		}
+	}
+
+	var results []memResult
+	seen := make(map[int32]bool)
+	for _, l := range lists {
+		for _, id := range l {
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+			if len(seen)%10000 == 0 && ctx.Err() != nil {
+				return nil, contextError(ctx.Err())
+			}
+			if score := m.score(id, p, &mq); score > 0 {
+				results = append(results, memResult{id, score})
+			}
+		}
+	}
+
+	sort.SliceStable(results, func(i, j int) bool { return results[i].score > results[j].score })
+	if uint64(len(results)) > p.n {
+		results = results[:p.n]
+	}
+
+	addresses := make([]Address, len(results))
+	for i, r := range results {
+		addresses[i] = m.address(r.id)
+		score := r.score
+		addresses[i].Score = &score
+		addresses[i].Fuzzy = fuzzy
+	}
+	return addresses, nil
+}
+
+type memResult struct {
+	id    int32
+	score float64
+}
+
+// score returns the relevance of the address id for the query, 0 if the
+// address does not match. It mirrors ftsScore and fuzzyScore.
+func (m *memStore) score(id int32, p *ftsParams, mq *memQuery) float64 {
+	a := &m.addresses[id]
+
+	if !matchPostcode(p.postcode, a.plz) || !likeMatch(p.citycode, a.gkz) {
+		return 0
+	}
+	// the province is the first digit of the Gemeindekennziffer
+	if p.province != "" && (a.gkz == "" || a.gkz[:1] != p.province) ||
+		p.district != "" && !strings.HasPrefix(a.gkz, p.district) {
+		return 0
+	}
+	if mq.number != "" && a.hausnrzahl1 != mq.number ||
+		mq.letter != "" && strings.ToLower(a.hausnrbuchstabe1) != mq.letter ||
+		mq.number2 != "" && a.hausnrzahl2 != mq.number2 ||
+		mq.letter2 != "" && strings.ToLower(a.hausnrbuchstabe2) != mq.letter2 ||
+		mq.name != "" && strings.ToLower(a.hofname) != mq.name && strings.ToLower(a.hausnrtext) != mq.name {
+		return 0
+	}
+
+	decay := 1.0
+	if mq.geo {
+		if !a.hasCoord {
+			return 0
+		}
+		d := distance(mq.lat, mq.lon, a.lat, a.lon)
+		if d > nearbymeters {
+			return 0
+		}
+		decay = 1 / (1 + d/nearbymeters)
+	}
+
+	tokens := m.tokens(a)
+	match := matchTerms(mq.q, tokens) *
+		matchTerms(mq.street, m.names[a.street].tokens) *
+		matchTerms(mq.city, m.names[a.municipality].tokens) *
+		matchTerms(mq.locality, m.names[a.locality].tokens)
+	if match == 0 {
+		return 0
+	}
+	if mq.fuzzy {
+		// the similarity is the score
+		return match * decay
+	}
+
+	// text rank: share of the address matched by the query
+	rank := float64(len(mq.q)+len(mq.street)+len(mq.city)+len(mq.locality)) / float64(len(tokens))
+	if rank > 1 {
+		rank = 1
+	}
+	var boost float64
+	if p.street != "" && strings.EqualFold(m.names[a.street].name, p.street) {
+		boost++
+	}
+	if mq.number != "" && strings.ToLower(a.hausnrbuchstabe1) == mq.letter {
+		boost++
+	}
+	return (rank*match + boost) / 3 * decay
+}
+
+// Reverse returns the addresses nearest to the point described by p
+func (m *memStore) Reverse(ctx context.Context, p *reverseParams) ([]Address, error) {
+	var results []memResult
+	for _, l := range m.nearby(p.lat, p.lon, p.radius) {
+		for _, id := range l {
+			a := &m.addresses[id]
+			if d := distance(p.lat, p.lon, a.lat, a.lon); d <= p.radius {
+				results = append(results, memResult{id, d})
+			}
+		}
+	}
+
+	sort.SliceStable(results, func(i, j int) bool { return results[i].score < results[j].score })
+	if uint64(len(results)) > p.n {
+		results = results[:p.n]
+	}
+
+	addresses := make([]Address, len(results))
+	for i, r := range results {
+		addresses[i] = m.address(r.id)
+		d := r.score
+		addresses[i].Distance = &d
+	}
+	return addresses, nil
+}
+
+// Detail returns the full record of the address with the address code adrcd
+func (m *memStore) Detail(ctx context.Context, adrcd, asof string) (*AddressDetail, error) {
+	id, ok := m.byADRCD[adrcd]
+	if !ok || m.before(asof) {
+		return nil, nil
+	}
+	a := &m.addresses[id]
+
+	d := AddressDetail{Address: m.address(id), GKZ: optional(a.gkz), OKZ: optional(a.okz), SKZ: optional(a.skz)}
+	for _, mb := range m.buildings[id] {
+		b := Building{SUBCD: mb.subcd, Hauptadresse: mb.hauptadresse, HausnrGebaeudeBez: optional(mb.hausnrgebaeudebez)}
+		if mb.epsg != 0 {
+			if lat, lon, err := mgi.ToWGS84(mb.epsg, mb.rw, mb.hw); err == nil {
+				b.LatlongY, b.LatlongX = &lat, &lon
+			}
+			b.Coordinates = pointCoordinates(b.LatlongY, b.LatlongX, &mb.rw, &mb.hw, &mb.epsg)
+		} else {
+			b.Coordinates = pointCoordinates(nil, nil, nil, nil, nil)
+		}
+		d.Buildings = append(d.Buildings, b)
+	}
+	sort.Slice(d.Buildings, func(i, j int) bool { return d.Buildings[i].SUBCD < d.Buildings[j].SUBCD })
+
+	if a.epsg != 0 {
+		d.complete(&a.rw, &a.hw, &a.epsg)
+	} else {
+		d.complete(nil, nil, nil)
+	}
+	return &d, nil
+}
+
+// History returns the address with the address code adrcd as single
+// version: the memory backend holds one release only
+func (m *memStore) History(ctx context.Context, adrcd string) ([]AddressVersion, error) {
+	id, ok := m.byADRCD[adrcd]
+	if !ok {
+		return nil, nil
+	}
+	return []AddressVersion{{Address: m.address(id), ValidFrom: m.stichtag.Format("2006-01-02")}}, nil
+}
+
+// before reports whether the date asof precedes the release, so that none
+// of its addresses were valid yet
+func (m *memStore) before(asof string) bool {
+	return asof != "" && asof < m.stichtag.Format("2006-01-02")
+}
+
+// catalogueEntry returns the code, name and code of the parent entry of the
+// entry of level the address a belongs to
+func (m *memStore) catalogueEntry(level catalogueLevel, a *memAddress) (code, name, parent string) {
+	switch level {
+	case levelProvinces:
+		code = a.gkz[:1]
+		return code, code, ""
+	case levelDistricts:
+		code = a.gkz[:3]
+		return code, code, a.gkz[:1]
+	case levelMunicipalities:
+		return a.gkz, m.names[a.municipality].name, a.gkz[:3]
+	case levelLocalities:
+		return a.okz, m.names[a.locality].name, a.gkz
+	case levelStreets:
+		return a.skz, m.names[a.street].name, a.okz
+	default:
+		return a.plz, a.plz, ""
+	}
+}
+
+// Catalogue lists the entries of level within the entry parent of the
+// level above
+func (m *memStore) Catalogue(ctx context.Context, level catalogueLevel, parent string) ([]CatalogueEntry, error) {
+	return m.aggregate(level, func(a *memAddress) bool {
+		_, _, p := m.catalogueEntry(level, a)
+		return parent == "" || p == parent
+	}), nil
+}
+
+// aggregate returns the entries of level of the addresses selected by match,
+// ordered by name
+func (m *memStore) aggregate(level catalogueLevel, match func(a *memAddress) bool) []CatalogueEntry {
+	type sum struct {
+		entry    CatalogueEntry
+		lat, lon float64
+		located  int
+	}
+	sums := make(map[string]*sum)
+
+	for i := range m.addresses {
+		a := &m.addresses[i]
+		if len(a.gkz) < 3 || !match(a) {
+			continue
+		}
+		code, name, _ := m.catalogueEntry(level, a)
+		s, ok := sums[code]
+		if !ok {
+			s = &sum{entry: CatalogueEntry{Code: code, Name: name}}
+			sums[code] = s
+		}
+		s.entry.Count++
+		if a.hasCoord {
+			s.lat += a.lat
+			s.lon += a.lon
+			s.located++
+		}
+	}
+
+	entries := make([]CatalogueEntry, 0, len(sums))
+	for _, s := range sums {
+		if s.located > 0 {
+			lat, lon := s.lat/float64(s.located), s.lon/float64(s.located)
+			s.entry.LatlongY, s.entry.LatlongX = &lat, &lon
+		}
+		entries = append(entries, s.entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Name != entries[j].Name {
+			return entries[i].Name < entries[j].Name
+		}
+		return entries[i].Code < entries[j].Code
+	})
+	return entries
+}
+
+// Postcode describes the area served by the postcode plz. The area is the
+// convex hull of the addresses.
+func (m *memStore) Postcode(ctx context.Context, plz string) (*Postcode, error) {
+	p := &Postcode{PLZ: plz}
+	var points [][2]float64
+	for i := range m.addresses {
+		a := &m.addresses[i]
+		if a.plz != plz {
+			continue
+		}
+		p.Count++
+		if !a.hasCoord {
+			continue
+		}
+		points = append(points, [2]float64{a.lon, a.lat})
+		if len(points) == 1 {
+			p.BBox = []float64{a.lon, a.lat, a.lon, a.lat}
+		}
+		p.BBox[0], p.BBox[1] = math.Min(p.BBox[0], a.lon), math.Min(p.BBox[1], a.lat)
+		p.BBox[2], p.BBox[3] = math.Max(p.BBox[2], a.lon), math.Max(p.BBox[3], a.lat)
+	}
+	if p.Count == 0 {
+		return p, nil
+	}
+	if hull := convexHull(points); hull != nil {
+		area, err := json.Marshal(geoJSONPolygon{Type: "Polygon", Coordinates: [][][2]float64{hull}})
+		if err != nil {
+			return nil, err
+		}
+		p.Area = area
+	}
+
+	match := func(a *memAddress) bool { return a.plz == plz }
+	p.Municipalities = m.aggregate(levelMunicipalities, match)
+	p.Localities = m.aggregate(levelLocalities, match)
+	p.Streets = m.aggregate(levelStreets, match)
+	return p, nil
+}
+
+// Postcodes lists the postcodes of the entry code of level
+func (m *memStore) Postcodes(ctx context.Context, level catalogueLevel, code string) ([]CatalogueEntry, error) {
+	return m.aggregate(levelPostcodes, func(a *memAddress) bool {
+		c, _, _ := m.catalogueEntry(level, a)
+		return c == code
+	}), nil
+}
+
+// HouseNumbers lists the addresses of the street skz, of the locality okz
+// only unless empty, ordered by house number
+func (m *memStore) HouseNumbers(ctx context.Context, skz, okz string) ([]Address, error) {
+	var ids []int32
+	for i := range m.addresses {
+		a := &m.addresses[i]
+		if a.skz == skz && (okz == "" || a.okz == okz) {
+			ids = append(ids, int32(i))
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		a, b := &m.addresses[ids[i]], &m.addresses[ids[j]]
+		na, _ := strconv.Atoi(a.hausnrzahl1)
+		nb, _ := strconv.Atoi(b.hausnrzahl1)
+		switch {
+		case na != nb:
+			return na < nb
+		case a.hausnrbuchstabe1 != b.hausnrbuchstabe1:
+			return a.hausnrbuchstabe1 < b.hausnrbuchstabe1
+		case a.hausnrzahl2 != b.hausnrzahl2:
+			za, _ := strconv.Atoi(a.hausnrzahl2)
+			zb, _ := strconv.Atoi(b.hausnrzahl2)
+			return za < zb
+		case a.hausnrbuchstabe2 != b.hausnrbuchstabe2:
+			return a.hausnrbuchstabe2 < b.hausnrbuchstabe2
+		case a.hofname != b.hofname:
+			return a.hofname < b.hofname
+		}
+		return a.adrcd < b.adrcd
+	})
+
+	addresses := make([]Address, len(ids))
+	for i, id := range ids {
+		addresses[i] = m.address(id)
+	}
+	return addresses, nil
+}
+
+// Dataset returns the Stichtag of the release and when it was loaded
+func (m *memStore) Dataset(ctx context.Context) (*Dataset, error) {
+	loaded := m.loaded
+	return &Dataset{Stichtag: m.stichtag.Format("2006-01-02"), Loaded: &loaded}, nil
+}
+
+// Changes lists no changes: the memory backend holds one release only
+func (m *memStore) Changes(ctx context.Context, p *changesParams, fn func(*Change) error) error {
+	return nil
+}
+
+// Ready reports an error when the release held no addresses
+func (m *memStore) Ready(ctx context.Context) error {
+	if len(m.addresses) == 0 {
+		return errors.New("no addresses loaded")
+	}
+	return nil
+}
+
+// Counts returns the number of addresses and buildings of the release
+func (m *memStore) Counts(ctx context.Context) (*RowCounts, error) {
+	c := RowCounts{Addresses: int64(len(m.addresses))}
+	for _, buildings := range m.buildings {
+		c.Buildings += int64(len(buildings))
+	}
+	return &c, nil
+}
+
+// address returns the Address of the address id
+func (m *memStore) address(id int32) Address {
+	a := &m.addresses[id]
+	addr := Address{
+		ADRCD:             optional(a.adrcd),
+		PLZ:               optional(a.plz),
+		Gemeindename:      optional(m.names[a.municipality].name),
+		Ortsname:          optional(m.names[a.locality].name),
+		Strassenname:      optional(m.names[a.street].name),
+		Hausnr:            optional(a.hausnrzahl1),
+		HausnrBuchstabe1:  optional(a.hausnrbuchstabe1),
+		HausnrVerbindung1: optional(a.hausnrverbindung1),
+		HausnrZahl2:       optional(a.hausnrzahl2),
+		HausnrBuchstabe2:  optional(a.hausnrbuchstabe2),
+		HausnrBereich:     optional(a.hausnrbereich),
+		HausnrText:        optional(a.hausnrtext),
+		Hofname:           optional(a.hofname),
+	}
+	if len(a.gkz) >= 3 {
+		addr.Bezirk = optional(a.gkz[:3])
+	}
+	if a.hasCoord {
+		lat, lon := a.lat, a.lon
+		addr.LatlongY, addr.LatlongX = &lat, &lon
+	}
+	addr.derive()
+	return addr
+}
+
+// optional returns nil for an empty string, as the database returns NULL
+func optional(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+// nearby returns the addresses of the grid cells within radius meters of the
+// point lat, lon. The cells are clamped to valid coordinates, where the
+// longitude span near the poles may cover the whole circle.
+func (m *memStore) nearby(lat, lon, radius float64) [][]int32 {
+	dlat := radius / 111320
+	dlon := radius / (111320 * math.Cos(lat*math.Pi/180))
+	if math.IsNaN(dlon) || dlon < 0 || dlon > 180 {
+		dlon = 180
+	}
+	from := cellOf(math.Max(lat-dlat, -90), math.Max(lon-dlon, -180))
+	to := cellOf(math.Min(lat+dlat, 90), math.Min(lon+dlon, 180))
+
+	lists := [][]int32{}
+	for y := int64(from.lat); y <= int64(to.lat); y++ {
+		for x := int64(from.lon); x <= int64(to.lon); x++ {
+			if l, ok := m.grid[gridCell{int32(y), int32(x)}]; ok {
+				lists = append(lists, l)
+			}
+		}
+	}
+	return lists
+}
+
+// distance returns the great circle distance in meters
+func distance(lat1, lon1, lat2, lon2 float64) float64 {
+	const earthRadius = 6371008.8
+	phi1, phi2 := lat1*math.Pi/180, lat2*math.Pi/180
+	dphi, dlam := phi2-phi1, (lon2-lon1)*math.Pi/180
+	h := math.Sin(dphi/2)*math.Sin(dphi/2) + math.Cos(phi1)*math.Cos(phi2)*math.Sin(dlam/2)*math.Sin(dlam/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(h))
+}
+
+func total(lists [][]int32) int {
+	n := 0
+	for _, l := range lists {
+		n += len(l)
+	}
+	return n
+}
+
+// likeMatch reports whether s matches the SQL LIKE pattern, where % matches
+// any sequence and _ any single character. An empty pattern matches anything.
+func likeMatch(pattern, s string) bool {
+	if pattern == "" {
+		return true
+	}
+	p, r := []rune(pattern), []rune(s)
+	var pi, ri int
+	star, mark := -1, 0
+	for ri < len(r) {
+		switch {
+		case pi < len(p) && (p[pi] == '_' || p[pi] == r[ri]):
+			pi++
+			ri++
+		case pi < len(p) && p[pi] == '%':
+			star, mark = pi, ri
+			pi++
+		case star >= 0:
+			pi = star + 1
+			mark++
+			ri = mark
+		default:
+			return false
+		}
+	}
+	for pi < len(p) && p[pi] == '%' {
+		pi++
+	}
+	return pi == len(p)
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// The metrics are exposed in the Prometheus text format at /metrics. The
+// format is simple enough to be written without a client library.
+
+var (
+	latencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+	resultBuckets  = []float64{0, 1, 2, 5, 10, 20, 50, 100, 200}
+)
+
+var (
+	httpRequests = newCounterVec("bevaddress_http_requests_total",
+		"Number of HTTP requests by endpoint and status code.", "endpoint", "code")
+	httpDuration = newHistogramVec("bevaddress_http_request_duration_seconds",
+		"Duration of HTTP requests by endpoint. Websocket requests last as long as the connection.", latencyBuckets, "endpoint")
+	upgradeFailures = newCounterVec("bevaddress_websocket_upgrade_failures_total",
+		"Number of failed upgrades to websocket connections by endpoint.", "endpoint")
+
+	searches = newCounterVec("bevaddress_searches_total",
+		"Number of searches by endpoint, mode (autocomplete or exact) and geo filter.", "endpoint", "mode", "geo")
+	searchErrors = newCounterVec("bevaddress_search_errors_total",
+		"Number of failed searches by endpoint, mode and geo filter.", "endpoint", "mode", "geo")
+	searchTimeouts = newCounterVec("bevaddress_search_timeouts_total",
+		"Number of searches which timed out by endpoint, mode and geo filter.", "endpoint", "mode", "geo")
+	zeroResults = newCounterVec("bevaddress_search_zero_results_total",
+		"Number of searches without result by endpoint, mode and geo filter.", "endpoint", "mode", "geo")
+	searchDuration = newHistogramVec("bevaddress_search_duration_seconds",
+		"Duration of searches by endpoint, mode and geo filter.", latencyBuckets, "endpoint", "mode", "geo")
+	searchResults = newHistogramVec("bevaddress_search_results",
+		"Number of results of searches by endpoint, mode and geo filter.", resultBuckets, "endpoint", "mode", "geo")
+)
+
+// collectors are written to /metrics in this order
+var collectors = []interface {
+	write(w io.Writer)
+}{httpRequests, httpDuration, upgradeFailures, searches, searchErrors, searchTimeouts, zeroResults, searchDuration, searchResults}
+
+// labelSet renders the label names and values as used in the text format
+func labelSet(names, values []string) string {
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + `="` + escapeLabel(values[i]) + `"`
+	}
+	return strings.Join(pairs, ",")
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+// formatFloat renders v as the text format expects it
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// counterVec is a counter partitioned by labels
+type counterVec struct {
+	name, help string
+	labels     []string
+
+	mu     sync.Mutex
+	values map[string]float64 // by label set
+}
+
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	return &counterVec{name: name, help: help, labels: labels, values: make(map[string]float64)}
+}
+
+// add adds v to the counter of the label values
+func (c *counterVec) add(v float64, values ...string) {
+	key := labelSet(c.labels, values)
+	c.mu.Lock()
+	c.values[key] += v
+	c.mu.Unlock()
+}
+
+func (c *counterVec) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	keys := make([]string, 0, len(c.values))
+	for key := range c.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s{%s} %s\n", c.name, key, formatFloat(c.values[key]))
+	}
+}
+
+// histogramVec is a histogram partitioned by labels
+type histogramVec struct {
+	name, help string
+	labels     []string
+	buckets    []float64 // upper bounds, ascending
+
+	mu     sync.Mutex
+	series map[string]*histogram // by label set
+}
+
+type histogram struct {
+	counts []uint64 // by bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *histogramVec {
+	return &histogramVec{name: name, help: help, labels: labels, buckets: buckets, series: make(map[string]*histogram)}
+}
+
+// observe adds v to the histogram of the label values
+func (h *histogramVec) observe(v float64, values ...string) {
+	key := labelSet(h.labels, values)
+	i := sort.SearchFloat64s(h.buckets, v)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	if i < len(h.buckets) {
+		s.counts[i]++
+	}
+	s.sum += v
+	s.count++
+}
+
+func (h *histogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := h.series[key]
+		var cumulative uint64
+		for i, le := range h.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket{%s,le=\"%s\"} %d\n", h.name, key, formatFloat(le), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", h.name, key, s.count)
+		fmt.Fprintf(w, "%s_sum{%s} %s\n", h.name, key, formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count{%s} %d\n", h.name, key, s.count)
+	}
+}
+
+// writeDBStats writes the statistics of the connection pool as gauges and
+// counters
+func writeDBStats(w io.Writer, db *sql.DB) {
+	st := db.Stats()
+	for _, m := range []struct {
+		name, kind, help string
+		value            float64
+	}{
+		{"bevaddress_db_max_open_connections", "gauge", "Maximum number of open connections to the database.", float64(st.MaxOpenConnections)},
+		{"bevaddress_db_open_connections", "gauge", "Number of established connections to the database.", float64(st.OpenConnections)},
+		{"bevaddress_db_in_use_connections", "gauge", "Number of connections currently in use.", float64(st.InUse)},
+		{"bevaddress_db_idle_connections", "gauge", "Number of idle connections.", float64(st.Idle)},
+		{"bevaddress_db_wait_count_total", "counter", "Number of connections waited for.", float64(st.WaitCount)},
+		{"bevaddress_db_wait_duration_seconds_total", "counter", "Time blocked waiting for a connection.", st.WaitDuration.Seconds()},
+	} {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %s\n", m.name, m.help, m.name, m.kind, m.name, formatFloat(m.value))
+	}
+}
+
+// metricsHandler serves the metrics in the Prometheus text format
+func (con *connection) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	bw := bufio.NewWriter(w)
+	for _, c := range collectors {
+		c.write(bw)
+	}
+	if con.db != nil {
+		writeDBStats(bw, con.db)
+	}
+	bw.Flush()
+}
+
+type endpointKey struct{}
+
+// endpoint returns the endpoint the request of ctx was routed to
+func endpoint(ctx context.Context) string {
+	e, _ := ctx.Value(endpointKey{}).(string)
+	return e
+}
+
+// instrument is the middleware counting and timing the requests by endpoint,
+// the path template of the matched route
+func instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e string
+		if route := mux.CurrentRoute(r); route != nil {
+			e, _ = route.GetPathTemplate()
+		}
+		r = r.WithContext(context.WithValue(r.Context(), endpointKey{}, e))
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+		next.ServeHTTP(rec, r)
+
+		httpRequests.add(1, e, strconv.Itoa(rec.status))
+		httpDuration.observe(time.Since(start).Seconds(), e)
+	})
+}
+
+// statusRecorder remembers the status code sent. It passes hijacking on for
+// websockets and flushing for streamed responses.
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (rec *statusRecorder) WriteHeader(code int) {
+	if !rec.wroteHeader {
+		rec.status, rec.wroteHeader = code, true
+	}
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+func (rec *statusRecorder) Flush() {
+	if f, ok := rec.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (rec *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := rec.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response does not support hijacking")
+	}
+	rec.status = http.StatusSwitchingProtocols
+	return h.Hijack()
+}
+
+// instrumentedStore records the searches of the AddressStore it wraps by
+// endpoint, mode and geo filter
+type instrumentedStore struct {
+	AddressStore
+}
+
+func (s instrumentedStore) Search(ctx context.Context, p *ftsParams) ([]Address, error) {
+	mode := "exact"
+	if p.autocomplete {
+		mode = "autocomplete"
+	}
+	geo := strconv.FormatBool(p.lat != "" && p.lon != "")
+	e := endpoint(ctx)
+
+	start := time.Now()
+	addresses, err := s.AddressStore.Search(ctx, p)
+	searches.add(1, e, mode, geo)
+	if err == errQueryCancelled {
+		// superseded in a search session or the client went away
+		return addresses, err
+	}
+	if err != nil {
+		searchErrors.add(1, e, mode, geo)
+		if err == errQueryTimeout {
+			searchTimeouts.add(1, e, mode, geo)
+		}
+		return addresses, err
+	}
+	searchDuration.observe(time.Since(start).Seconds(), e, mode, geo)
+	searchResults.observe(float64(len(addresses)), e, mode, geo)
+	if len(addresses) == 0 {
+		zeroResults.add(1, e, mode, geo)
+	}
+	return addresses, err
+}
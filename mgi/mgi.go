@@ -0,0 +1,125 @@
+// Package mgi converts coordinates of the Austrian Gauß-Krüger projections
+// of the MGI datum, as used by the BEV Adressregister, to WGS84.
+//
+// Supported are the meridian strips EPSG 31254 (M28, West), 31255 (M31,
+// Central) and 31256 (M34, East). The datum shift uses the seven parameter
+// transformation EPSG 1618, which is accurate to about one meter.
+package mgi
+
+import (
+	"errors"
+	"math"
+	"strconv"
+)
+
+// Bessel 1841 ellipsoid of the MGI datum
+const (
+	besselA = 6377397.155
+	besselF = 1 / 299.1528128
+)
+
+// WGS84 ellipsoid
+const (
+	wgs84A = 6378137.0
+	wgs84F = 1 / 298.257223563
+)
+
+// Helmert parameters MGI to WGS84, EPSG 1618, position vector convention
+const (
+	tx = 577.326
+	ty = 90.129
+	tz = 463.919
+	rx = 5.137 * math.Pi / (180 * 3600)
+	ry = 1.474 * math.Pi / (180 * 3600)
+	rz = 5.297 * math.Pi / (180 * 3600)
+	ds = 2.4232e-6
+)
+
+const falseNorthing = -5000000
+
+// centralMeridian returns the central meridian in degrees of the Gauß-Krüger
+// strip epsg
+func centralMeridian(epsg int) (float64, error) {
+	switch epsg {
+	case 31254:
+		return 10 + 20.0/60, nil
+	case 31255:
+		return 13 + 20.0/60, nil
+	case 31256:
+		return 16 + 20.0/60, nil
+	}
+	return 0, errors.New("unsupported coordinate reference system EPSG " + strconv.Itoa(epsg))
+}
+
+// ToWGS84 converts the Gauß-Krüger coordinates rw (Rechtswert, easting) and
+// hw (Hochwert, northing) of the strip epsg to WGS84 latitude and longitude
+// in degrees
+func ToWGS84(epsg int, rw, hw float64) (lat, lon float64, err error) {
+	lon0, err := centralMeridian(epsg)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	phi, lam := inverseTM(rw, hw-falseNorthing, lon0*math.Pi/180)
+	x, y, z := toCartesian(phi, lam, besselA, besselF)
+
+	x, y, z = tx+(1+ds)*(x-rz*y+ry*z),
+		ty+(1+ds)*(rz*x+y-rx*z),
+		tz+(1+ds)*(-ry*x+rx*y+z)
+
+	phi, lam = fromCartesian(x, y, z, wgs84A, wgs84F)
+	return phi * 180 / math.Pi, lam * 180 / math.Pi, nil
+}
+
+// inverseTM returns latitude and longitude in radians on the Bessel ellipsoid
+// of the transverse mercator coordinates x and y with scale 1 and central
+// meridian lam0 (Snyder, Map Projections, p. 63)
+func inverseTM(x, y, lam0 float64) (phi, lam float64) {
+	e2 := besselF * (2 - besselF)
+	ep2 := e2 / (1 - e2)
+	e1 := (1 - math.Sqrt(1-e2)) / (1 + math.Sqrt(1-e2))
+
+	mu := y / (besselA * (1 - e2/4 - 3*e2*e2/64 - 5*e2*e2*e2/256))
+	phi1 := mu + (3*e1/2-27*math.Pow(e1, 3)/32)*math.Sin(2*mu) +
+		(21*e1*e1/16-55*math.Pow(e1, 4)/32)*math.Sin(4*mu) +
+		(151*math.Pow(e1, 3)/96)*math.Sin(6*mu) +
+		(1097*math.Pow(e1, 4)/512)*math.Sin(8*mu)
+
+	sin1, cos1, tan1 := math.Sin(phi1), math.Cos(phi1), math.Tan(phi1)
+	c1 := ep2 * cos1 * cos1
+	t1 := tan1 * tan1
+	n1 := besselA / math.Sqrt(1-e2*sin1*sin1)
+	r1 := besselA * (1 - e2) / math.Pow(1-e2*sin1*sin1, 1.5)
+	d := x / n1
+
+	phi = phi1 - (n1*tan1/r1)*(d*d/2-
+		(5+3*t1+10*c1-4*c1*c1-9*ep2)*math.Pow(d, 4)/24+
+		(61+90*t1+298*c1+45*t1*t1-252*ep2-3*c1*c1)*math.Pow(d, 6)/720)
+	lam = lam0 + (d-
+		(1+2*t1+c1)*math.Pow(d, 3)/6+
+		(5-2*c1+28*t1-3*c1*c1+8*ep2+24*t1*t1)*math.Pow(d, 5)/120)/cos1
+	return phi, lam
+}
+
+// toCartesian returns the geocentric coordinates of a point on the surface of
+// the ellipsoid a, f
+func toCartesian(phi, lam, a, f float64) (x, y, z float64) {
+	e2 := f * (2 - f)
+	n := a / math.Sqrt(1-e2*math.Sin(phi)*math.Sin(phi))
+	return n * math.Cos(phi) * math.Cos(lam),
+		n * math.Cos(phi) * math.Sin(lam),
+		n * (1 - e2) * math.Sin(phi)
+}
+
+// fromCartesian returns latitude and longitude on the ellipsoid a, f of the
+// geocentric coordinates x, y, z
+func fromCartesian(x, y, z, a, f float64) (phi, lam float64) {
+	e2 := f * (2 - f)
+	p := math.Hypot(x, y)
+	phi = math.Atan2(z, p*(1-e2))
+	for i := 0; i < 5; i++ {
+		n := a / math.Sqrt(1-e2*math.Sin(phi)*math.Sin(phi))
+		phi = math.Atan2(z+e2*n*math.Sin(phi), p)
+	}
+	return phi, math.Atan2(y, x)
+}
@@ -0,0 +1,46 @@
+package mgi
+
+import (
+	"math"
+	"testing"
+)
+
+// The Gauß-Krüger coordinates of the reference points were computed from
+// their WGS84 position by an independent implementation of the forward
+// transformation: the inverse of the datum shift EPSG 1618 solved as a
+// linear system and the Krüger series of the transverse mercator (Karney,
+// Transverse Mercator with an accuracy of a few nanometers, 2011), with the
+// false northing of -5000000 m of the Austrian strips.
+func TestToWGS84(t *testing.T) {
+	tests := []struct {
+		place    string
+		epsg     int
+		rw, hw   float64
+		lat, lon float64
+	}{
+		{"Innsbruck", 31254, 80235.75, 237181.42, 47.2686, 11.3933},
+		{"Bregenz", 31254, -44149.39, 262867.91, 47.5030, 9.7470},
+		{"Salzburg", 31255, -21394.24, 295518.54, 47.7979, 13.0470},
+		{"Klagenfurt", 31255, 74668.29, 165516.48, 46.6248, 14.3076},
+		{"Wien", 31256, 3044.76, 341122.70, 48.2085, 16.3731},
+		{"Graz", 31256, -67807.25, 215007.33, 47.0707, 15.4395},
+	}
+	for _, tt := range tests {
+		lat, lon, err := ToWGS84(tt.epsg, tt.rw, tt.hw)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.place, err)
+		}
+		// metres per degree of latitude and longitude
+		dy := (lat - tt.lat) * 111200
+		dx := (lon - tt.lon) * 111200 * math.Cos(tt.lat*math.Pi/180)
+		if d := math.Hypot(dx, dy); d > 1 {
+			t.Errorf("%s: ToWGS84(%d, %v, %v) = %.7f, %.7f, %.2f m off %v, %v", tt.place, tt.epsg, tt.rw, tt.hw, lat, lon, d, tt.lat, tt.lon)
+		}
+	}
+}
+
+func TestToWGS84Unsupported(t *testing.T) {
+	if _, _, err := ToWGS84(31287, 0, 0); err == nil {
+		t.Error("EPSG 31287: no error")
+	}
+}
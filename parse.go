@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/the42/bevaddressapi/addrparse"
+)
+
+// parsed returns the structured query for the components of the free-text
+// query q, or nil when q cannot be split into street and house number. The
+// municipality and locality are kept as full text search, as the parser
+// cannot tell one from the other.
+func (p *ftsParams) parsed() *ftsParams {
+	if p.q == "" || p.structured() {
+		return nil
+	}
+
+	a := addrparse.Parse(p.q)
+	if a.Street == "" || a.HouseNumber == "" {
+		return nil
+	}
+
+	pp := *p
+	pp.q = strings.TrimSpace(a.Municipality + " " + a.Locality)
+	pp.street = a.Street
+	pp.housenumber = a.FormatHouseNumber()
+	if pp.postcode == "" {
+		pp.postcode = a.Postcode
+	}
+	return &pp
+}
+
+// parseAddress returns the components of the address in parameter q as
+// recognised by the parser. It helps to debug unexpected search results.
+func parseAddress(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query().Get("q")
+	if q == "" {
+		httpError(w, "parameter q is required", http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, r, addrparse.Parse(q))
+}
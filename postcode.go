@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// Postcode describes the area served by a postcode (Postleitzahl)
+type Postcode struct {
+	PLZ   string
+	Count int64 // number of addresses
+	// BBox is the bounding box of the addresses: minimum longitude and
+	// latitude, maximum longitude and latitude
+	BBox []float64 `json:",omitempty"`
+	// Area is a GeoJSON polygon enclosing the addresses, missing when they
+	// do not span an area
+	Area           json.RawMessage `json:",omitempty"`
+	Municipalities []CatalogueEntry
+	Localities     []CatalogueEntry
+	Streets        []CatalogueEntry
+}
+
+// validatePostcode checks the filter postcode, a comma separated list of
+// postcodes, ranges of postcodes like 3500-3599 and patterns like 35%
+func validatePostcode(postcode string) error {
+	if postcode == "" {
+		return nil
+	}
+	for _, item := range strings.Split(postcode, ",") {
+		if lo, hi, ok := postcodeRange(item); ok {
+			if !isPostcode(lo) || !isPostcode(hi) || lo > hi {
+				return errors.New("parameter postcode: malformed range " + item)
+			}
+			continue
+		}
+		if item == "" || len(item) > 4 || strings.Trim(item, "0123456789%_") != "" {
+			return errors.New("parameter postcode: malformed postcode " + item)
+		}
+	}
+	return nil
+}
+
+// postcodeRange splits item of the form lo-hi
+func postcodeRange(item string) (lo, hi string, ok bool) {
+	i := strings.IndexByte(item, '-')
+	if i < 0 {
+		return "", "", false
+	}
+	return item[:i], item[i+1:], true
+}
+
+// isPostcode reports whether s is an Austrian postcode
+func isPostcode(s string) bool {
+	return len(s) == 4 && strings.Trim(s, "0123456789") == ""
+}
+
+// matchPostcode reports whether plz matches the filter postcode, see
+// validatePostcode. It mirrors postcodeFilter.
+func matchPostcode(postcode, plz string) bool {
+	if postcode == "" {
+		return true
+	}
+	for _, item := range strings.Split(postcode, ",") {
+		if lo, hi, ok := postcodeRange(item); ok {
+			if lo <= plz && plz <= hi {
+				return true
+			}
+		} else if likeMatch(item, plz) {
+			return true
+		}
+	}
+	return false
+}
+
+// postcode serves the description of the postcode plz
+func (con *connection) postcode(w http.ResponseWriter, r *http.Request) {
+	plz := mux.Vars(r)["plz"]
+
+	p, err := con.store.Postcode(r.Context(), plz)
+	if err != nil {
+		storeError(w, err)
+		return
+	}
+	if p.Count == 0 {
+		httpError(w, "no such postcode "+plz, http.StatusNotFound)
+		return
+	}
+	writeJSON(w, r, p)
+}
+
+// postcodes serves the postcodes of the municipality given by parameter gkz
+// or of the locality given by parameter okz
+func (con *connection) postcodes(w http.ResponseWriter, r *http.Request) {
+	gkz, okz := r.URL.Query().Get("gkz"), r.URL.Query().Get("okz")
+
+	var level catalogueLevel
+	var code string
+	switch {
+	case gkz != "" && okz == "":
+		level, code = levelMunicipalities, gkz
+	case okz != "" && gkz == "":
+		level, code = levelLocalities, okz
+	default:
+		httpError(w, "either parameter gkz or okz has to be set", http.StatusBadRequest)
+		return
+	}
+
+	entries, err := con.store.Postcodes(r.Context(), level, code)
+	if err != nil {
+		storeError(w, err)
+		return
+	}
+	if len(entries) == 0 {
+		httpError(w, "no such entry "+code, http.StatusNotFound)
+		return
+	}
+	writeJSON(w, r, entries)
+}
+
+// geoJSONPolygon is a polygon without holes
+type geoJSONPolygon struct {
+	Type        string         `json:"type"`
+	Coordinates [][][2]float64 `json:"coordinates"` // rings of longitude, latitude
+}
+
+// convexHull returns the convex hull of points as closed ring, counter
+// clockwise, or nil when the points do not span an area
+func convexHull(points [][2]float64) [][2]float64 {
+	points = append([][2]float64(nil), points...)
+	sort.Slice(points, func(i, j int) bool {
+		if points[i][0] != points[j][0] {
+			return points[i][0] < points[j][0]
+		}
+		return points[i][1] < points[j][1]
+	})
+
+	cross := func(o, a, b [2]float64) float64 {
+		return (a[0]-o[0])*(b[1]-o[1]) - (a[1]-o[1])*(b[0]-o[0])
+	}
+
+	// Andrew's monotone chain: the lower hull, then the upper hull
+	hull := make([][2]float64, 0, 2*len(points))
+	for _, p := range points {
+		for len(hull) >= 2 && cross(hull[len(hull)-2], hull[len(hull)-1], p) <= 0 {
+			hull = hull[:len(hull)-1]
+		}
+		hull = append(hull, p)
+	}
+	for i, lower := len(points)-2, len(hull)+1; i >= 0; i-- {
+		for len(hull) >= lower && cross(hull[len(hull)-2], hull[len(hull)-1], points[i]) <= 0 {
+			hull = hull[:len(hull)-1]
+		}
+		hull = append(hull, points[i])
+	}
+
+	// the last point closes the ring
+	if len(hull) < 4 {
+		return nil
+	}
+	return hull
+}
@@ -0,0 +1,707 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/the42/bevaddressapi/addrparse"
+)
+
+// postgisStore is the AddressStore backed by the PostGIS database loaded by
+// the import command
+type postgisStore struct {
+	*sql.DB
+	trigram bool // pg_trgm is installed, otherwise the fuzzy search tier is disabled, see detectTrigram
+}
+
+// dbError returns errQueryTimeout when err tells that the query was cancelled
+// by the statement timeout or its context ran out of time, errQueryCancelled
+// when its context was cancelled, otherwise err prefixed by what failed
+func dbError(what string, err error) error {
+	var e *pq.Error
+	if errors.As(err, &e) && e.Code == "57014" { // query_canceled
+		if strings.Contains(e.Message, "statement timeout") {
+			return errQueryTimeout
+		}
+		return errQueryCancelled
+	}
+	if err := contextError(err); err == errQueryTimeout || err == errQueryCancelled {
+		return err
+	}
+	return errors.New(what + ": " + err.Error())
+}
+
+// addressColumns are the columns selected for an Address, in the order
+// expected by Address.dest
+const addressColumns = `addritems.adrcd, addritems.plz, addritems.gemeindename, addritems.ortsname, addritems.strassenname, addritems.hausnrzahl1,
+addritems.hausnrbuchstabe1, addritems.hausnrverbindung1, addritems.hausnrzahl2, addritems.hausnrbuchstabe2, addritems.hausnrbereich, addritems.hausnrtext, addritems.hofname,
+ST_Y(adresse.latlong), ST_X(adresse.latlong), substr(addritems.gkz, 1, 3)`
+
+// dest returns the scan destinations for addressColumns
+func (a *Address) dest() []interface{} {
+	return []interface{}{&a.ADRCD, &a.PLZ, &a.Gemeindename, &a.Ortsname, &a.Strassenname, &a.Hausnr,
+		&a.HausnrBuchstabe1, &a.HausnrVerbindung1, &a.HausnrZahl2, &a.HausnrBuchstabe2, &a.HausnrBereich, &a.HausnrText, &a.Hofname,
+		&a.LatlongY, &a.LatlongX, &a.Bezirk}
+}
+
+// scanner is implemented by *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scan reads addressColumns followed by the columns read into extra
+func (a *Address) scan(s scanner, extra ...interface{}) error {
+	if err := s.Scan(append(a.dest(), extra...)...); err != nil {
+		return err
+	}
+	a.derive()
+	return nil
+}
+
+const autocomplete = `%s @@ (%s::text || ':*')::tsquery`
+const noautocomplete = `%s @@ %s`
+
+// tsquery returns the query of the text given by the SQL expression text in
+// the configured text search configuration
+func tsquery(text string) string {
+	return "plainto_tsquery('" + textSearchConfig + "', " + text + ")"
+}
+
+// refPoint is the reference point given by lat ($5) and lon ($6)
+const refPoint = `ST_GeomFromText('POINT(' || $6 || ' ' || $5 || ')', 4326)::geography`
+
+// ftsScore ranks the results of a full text search. The text rank of the
+// free-text and structured query (1) is combined with a boost for an exact
+// match of the street and of the house number without letter suffix, and
+// decays with the distance to the reference point, if given.
+const ftsScore = `(ts_rank_cd(search, %[1]s, 32)
++ CASE WHEN $9 <> '' AND lower(addritems.strassenname) = lower($9) THEN 1 ELSE 0 END
++ CASE WHEN $10 <> '' AND lower(COALESCE(addritems.hausnrbuchstabe1, '')) = lower($13) THEN 1 ELSE 0 END) / 3
+* CASE ($5 = '' AND $6 = '') WHEN NOT FALSE THEN 1 ELSE 1 / (1 + ST_Distance(latlong_g, ` + refPoint + `) / $7) END`
+
+// rankTerms joins the free-text and structured query for ranking
+const rankTerms = `$1 || ' ' || $9 || ' ' || $10 || ' ' || $11 || ' ' || $12`
+
+// validAt restricts table to the version valid at the date given by the SQL
+// parameter asof, to the current version when asof is empty
+func validAt(table, asof string) string {
+	return "(" + table + ".valid_to IS NULL AND " + asof + " = '' OR " + validOn(table, "CAST(NULLIF("+asof+", '') AS date)") + ")"
+}
+
+// validOn restricts table to the version valid at the date expression date
+func validOn(table, date string) string {
+	return "(" + table + ".valid_from <= " + date + " AND COALESCE(" + table + ".valid_to > " + date + ", TRUE))"
+}
+
+// postcodeFilter matches addritems.plz against the comma separated list of
+// postcodes, ranges lo-hi and patterns $2. It mirrors matchPostcode.
+const postcodeFilter = `($2 = '' OR EXISTS (select 1 from unnest(string_to_array($2, ',')) item
+where CASE WHEN position('-' in item) > 0
+THEN addritems.plz between split_part(item, '-', 1) and split_part(item, '-', 2)
+ELSE addritems.plz like item END))`
+
+// ftsFilters restricts a search by postcode ($2), citycode ($3), province
+// ($4), district ($18) and distance to the reference point ($5, $6, $7). The
+// house number is matched by its parts: number ($10), letter ($13), second
+// number ($14) and letter ($15), or by the name given instead of a number
+// ($16). Addresses are searched as they were at the date $17, by default as
+// they are now.
+var ftsFilters = `
+and ` + validAt("adresse", "$17") + `
+and ` + validAt("addritems", "$17") + `
+and ` + postcodeFilter + `
+and addritems.gkz like COALESCE(NULLIF($3, ''), addritems.gkz)
+and addritems.bld = COALESCE(CAST(NULLIF($4, '') AS smallint), addritems.bld)
+and ($18 = '' OR substr(addritems.gkz, 1, 3) = $18)
+and CASE ($5 = '' AND $6='') WHEN NOT FALSE THEN TRUE ELSE ST_DWithin(latlong_g, ` + refPoint + `, $7, false) END
+and ($10 = '' OR CAST(addritems.hausnrzahl1 AS text) = $10)
+and ($13 = '' OR lower(addritems.hausnrbuchstabe1) = lower($13))
+and ($14 = '' OR CAST(addritems.hausnrzahl2 AS text) = $14)
+and ($15 = '' OR lower(addritems.hausnrbuchstabe2) = lower($15))
+and ($16 = '' OR lower(addritems.hofname) = lower($16) OR lower(addritems.hausnrtext) = lower($16))`
+
+// fulltextSearchSQL is completed by ftsParams.sql with the score (1), the
+// match of q (2) and the matches of the structured fields street (3), city (4)
+// and locality (5)
+var fulltextSearchSQL = `select ` + addressColumns + `, %[1]s as score
+from adresse
+inner join addritems
+on addritems.adrcd = adresse.adrcd
+and %[2]s` + ftsFilters + `
+and %[3]s
+and %[4]s
+and %[5]s
+order by score desc
+limit $8`
+
+const reverseSearchSQL = `select ` + addressColumns + `, ST_Distance(adresse.latlong_g, ref.g)
+from adresse
+inner join addritems
+on addritems.adrcd = adresse.adrcd,
+(select ST_SetSRID(ST_MakePoint($2, $1), 4326)::geography as g) ref
+where ST_DWithin(adresse.latlong_g, ref.g, $3, false)
+and adresse.valid_to is null
+and addritems.valid_to is null
+order by ST_Distance(adresse.latlong_g, ref.g)
+limit $4`
+
+var detailSQL = `select ` + addressColumns + `, addritems.gkz, addritems.okz, addritems.skz, adresse.rw, adresse.hw, adresse.epsg
+from adresse
+inner join addritems
+on addritems.adrcd = adresse.adrcd
+where addritems.adrcd = $1
+and ` + validAt("adresse", "$2") + `
+and ` + validAt("addritems", "$2") + `
+limit 1`
+
+var buildingsSQL = `select subcd, hauptadresse, hausnrgebaeudebez, ST_Y(latlong), ST_X(latlong), rw, hw, epsg
+from gebaeude
+where adrcd = $1
+and ` + validAt("gebaeude", "$2") + `
+order by subcd`
+
+// historySQL lists the versions of an address. A version lasts while neither
+// the location (adresse) nor the other items of the address (addritems)
+// change; a missing end means the version is current.
+const historySQL = `select ` + addressColumns + `,
+to_char(greatest(adresse.valid_from, addritems.valid_from), 'YYYY-MM-DD'),
+to_char(least(adresse.valid_to, addritems.valid_to), 'YYYY-MM-DD')
+from adresse
+inner join addritems
+on addritems.adrcd = adresse.adrcd
+and adresse.valid_from < COALESCE(addritems.valid_to, 'infinity')
+and addritems.valid_from < COALESCE(adresse.valid_to, 'infinity')
+where addritems.adrcd = $1
+order by greatest(adresse.valid_from, addritems.valid_from)`
+
+// catalogueColumns are the code, name and code of the parent entry of the
+// entries of each catalogue level. Provinces and districts are not named by
+// the release, their code stands in for the name. The parent is compared as
+// indexed by the import, see indexStagingSQL.
+var catalogueColumns = map[catalogueLevel][3]string{
+	levelProvinces:      {"CAST(addritems.bld AS text)", "CAST(addritems.bld AS text)", "''"},
+	levelDistricts:      {"substr(addritems.gkz, 1, 3)", "substr(addritems.gkz, 1, 3)", "addritems.bld"},
+	levelMunicipalities: {"addritems.gkz", "addritems.gemeindename", "substr(addritems.gkz, 1, 3)"},
+	levelLocalities:     {"addritems.okz", "addritems.ortsname", "addritems.gkz"},
+	levelStreets:        {"addritems.skz", "addritems.strassenname", "addritems.okz"},
+	levelPostcodes:      {"addritems.plz", "addritems.plz", "''"},
+}
+
+// catalogueSQL is completed with the code (1), name (2) and parent (3) of a
+// catalogue level and the type of the parent (4) and lists its entries
+// within the parent $1, all entries when $1 is empty
+const catalogueSQL = `select %[1]s, min(%[2]s), count(*), avg(ST_X(adresse.latlong)), avg(ST_Y(adresse.latlong))
+from addritems
+left join adresse
+on adresse.adrcd = addritems.adrcd
+and adresse.valid_to is null
+where addritems.valid_to is null
+and ($1 = '' OR %[3]s = CAST(NULLIF($1, '') AS %[4]s))
+group by 1
+order by 2, 1`
+
+// columnType is the type a code is bound as when compared to column, so that
+// the column itself is not cast
+func columnType(column string) string {
+	if column == "addritems.bld" {
+		return "smallint"
+	}
+	return "text"
+}
+
+// postcodeSQL counts the addresses of the postcode $1 and returns their
+// bounding box and concave hull, the latter only if it is a polygon
+const postcodeSQL = `select n, ST_XMin(extent), ST_YMin(extent), ST_XMax(extent), ST_YMax(extent),
+CASE WHEN ST_GeometryType(hull) = 'ST_Polygon' THEN ST_AsGeoJSON(hull, 6) END
+from (select count(*) as n, ST_Extent(adresse.latlong) as extent, ST_ConcaveHull(ST_Collect(adresse.latlong), 0.8) as hull
+from addritems
+left join adresse
+on adresse.adrcd = addritems.adrcd
+and adresse.valid_to is null
+where addritems.valid_to is null
+and addritems.plz = $1) p`
+
+// houseNumbersSQL lists the addresses of the street $1, restricted to the
+// locality $2 unless empty
+const houseNumbersSQL = `select ` + addressColumns + `, CAST(NULL AS double precision)
+from adresse
+inner join addritems
+on addritems.adrcd = adresse.adrcd
+where addritems.skz = $1
+and ($2 = '' OR addritems.okz = $2)
+and adresse.valid_to is null
+and addritems.valid_to is null
+order by addritems.hausnrzahl1, addritems.hausnrbuchstabe1, addritems.hausnrzahl2, addritems.hausnrbuchstabe2, addritems.hofname, addritems.adrcd`
+
+// changesGoneSQL tells whether a release was loaded in full after the
+// release of Stichtag $1, after the time $2 or after the change $3
+const changesGoneSQL = `select exists(select 1 from import_runs f
+where f.mode = 'full'
+and ($1 <> '' AND f.stichtag > CAST(NULLIF($1, '') AS date)
+or $2 <> '' AND f.finished > CAST(NULLIF($2, '') AS timestamptz)
+or CAST($3 AS bigint) > 0 AND f.id > COALESCE((select run_id from address_changes where seq = $3), 0)))`
+
+// changeVersion selects the address of a change as it was at date
+func changeVersion(date string) string {
+	return `(select true as found, ` + addressColumns + `
+from adresse
+inner join addritems
+on addritems.adrcd = adresse.adrcd
+where addritems.adrcd = c.adrcd
+and ` + validOn("adresse", date) + `
+and ` + validOn("addritems", date) + `
+limit 1)`
+}
+
+// changesSQL lists the changes of the releases after the release of Stichtag
+// $1 and loaded after the time $2, starting after the change $3, at most $4.
+// Only the changes since the latest full import are kept apart.
+var changesSQL = `select c.seq, to_char(c.stichtag, 'YYYY-MM-DD'), c.adrcd, c.change, b.*, a.*
+from address_changes c
+inner join import_runs r
+on r.id = c.run_id
+left join lateral ` + changeVersion("c.stichtag - 1") + ` b on true
+left join lateral ` + changeVersion("c.stichtag") + ` a on true
+where c.seq > $3
+and r.id > (select COALESCE(max(id), 0) from import_runs where mode = 'full')
+and ($1 = '' OR r.stichtag > CAST(NULLIF($1, '') AS date))
+and ($2 = '' OR r.finished > CAST(NULLIF($2, '') AS timestamptz))
+order by c.seq
+limit $4`
+
+const datasetSQL = `select to_char(stichtag, 'YYYY-MM-DD'), finished
+from import_runs
+order by id desc
+limit 1`
+
+// match returns the condition matching the text search vector against the
+// query in the SQL parameter param
+func (p *ftsParams) match(vector, param string) string {
+	if p.autocomplete {
+		return fmt.Sprintf(autocomplete, vector, tsquery(param))
+	}
+	return fmt.Sprintf(noautocomplete, vector, tsquery(param))
+}
+
+// fieldMatch returns the condition matching a single address component. The
+// search vector of the address preselects candidates using the index, the
+// match is confirmed against column only.
+func (p *ftsParams) fieldMatch(column, param string) string {
+	return "(" + param + " = '' OR (" + p.match("search", param) + " AND " + p.match("to_tsvector('"+textSearchConfig+"', "+column+")", param) + "))"
+}
+
+// sql returns the full text search statement for p
+func (p *ftsParams) sql() string {
+	qmatch := p.match("search", "$1")
+	if p.structured() {
+		qmatch = "($1 = '' OR " + qmatch + ")"
+	}
+
+	rank := tsquery(rankTerms)
+	if p.autocomplete {
+		// prefix match of the last word, the query may be empty
+		rank = "COALESCE(NULLIF(" + rank + "::text, '') || ':*', '')::tsquery"
+	}
+
+	return fmt.Sprintf(fulltextSearchSQL, fmt.Sprintf(ftsScore, rank), qmatch,
+		p.fieldMatch("addritems.strassenname", "$9"),
+		p.fieldMatch("addritems.gemeindename", "$11"),
+		p.fieldMatch("addritems.ortsname", "$12"))
+}
+
+// args returns the SQL parameters of the statement returned by sql
+func (p *ftsParams) args() []interface{} {
+	var number, letter, number2, letter2, name string
+	if p.housenumber != "" {
+		if hnr, ok := addrparse.ParseHouseNumber(p.housenumber); ok {
+			number, letter, number2, letter2 = hnr.HouseNumber, hnr.Letter, hnr.HouseNumberTo, hnr.LetterTo
+		} else {
+			name = p.housenumber
+		}
+	}
+	return []interface{}{p.q, p.postcode, p.citycode, p.province, p.lat, p.lon, nearbymeters, p.n, p.street, number, p.city, p.locality, letter, number2, letter2, name, p.asof, p.district}
+}
+
+// Search runs the full text search described by p against the database.
+// A free-text query is split into its components first; only when searching
+// for the components yields no result, the query is run as typed. When this
+// yields no result either, the fuzzy search tier takes over.
+func (pg *postgisStore) Search(ctx context.Context, p *ftsParams) ([]Address, error) {
+	pp := p.parsed()
+	fuzzy := p.fuzzy
+	if !pg.trigram {
+		fuzzy = fuzzyNever
+	}
+
+	if fuzzy != fuzzyAlways {
+		if pp != nil {
+			if addresses, err := pg.queryAddresses(ctx, pp.sql(), pp.args()); err != nil || len(addresses) > 0 {
+				return addresses, err
+			}
+		}
+		addresses, err := pg.queryAddresses(ctx, p.sql(), p.args())
+		if err != nil || len(addresses) > 0 || fuzzy == fuzzyNever {
+			return addresses, err
+		}
+	}
+
+	if pp != nil {
+		p = pp
+	}
+	return pg.queryFuzzy(ctx, p)
+}
+
+// queryAddresses runs a search statement which returns addressColumns
+// followed by the score
+func (pg *postgisStore) queryAddresses(ctx context.Context, query string, args []interface{}) ([]Address, error) {
+	rows, err := pg.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, dbError("database query failed", err)
+	}
+	defer rows.Close()
+
+	var addresses []Address
+
+	for rows.Next() {
+		var addr Address
+		if err = addr.scan(rows, &addr.Score); err != nil {
+			return nil, dbError("reading from database failed", err)
+		}
+		addresses = append(addresses, addr)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, dbError("reading from database failed", err)
+	}
+	return addresses, nil
+}
+
+// Reverse returns the addresses nearest to the point described by p,
+// ordered by distance
+func (pg *postgisStore) Reverse(ctx context.Context, p *reverseParams) ([]Address, error) {
+	rows, err := pg.QueryContext(ctx, reverseSearchSQL, p.lat, p.lon, p.radius, p.n)
+	if err != nil {
+		return nil, dbError("database query failed", err)
+	}
+	defer rows.Close()
+
+	var addresses []Address
+
+	for rows.Next() {
+		var addr Address
+		if err = addr.scan(rows, &addr.Distance); err != nil {
+			return nil, dbError("reading from database failed", err)
+		}
+		addresses = append(addresses, addr)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, dbError("reading from database failed", err)
+	}
+	return addresses, nil
+}
+
+// Detail returns the full record of the address with the address code adrcd
+// as it was at the date asof, as it is now when asof is empty
+func (pg *postgisStore) Detail(ctx context.Context, adrcd, asof string) (*AddressDetail, error) {
+	var d AddressDetail
+	var rw, hw *float64
+	var epsg *int
+	err := d.scan(pg.QueryRowContext(ctx, detailSQL, adrcd, asof), &d.GKZ, &d.OKZ, &d.SKZ, &rw, &hw, &epsg)
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, nil
+	case err != nil:
+		return nil, dbError("database query failed", err)
+	}
+
+	rows, err := pg.QueryContext(ctx, buildingsSQL, adrcd, asof)
+	if err != nil {
+		return nil, dbError("database query failed", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var b Building
+		var hauptadresse *string
+		var brw, bhw *float64
+		var bepsg *int
+		if err = rows.Scan(&b.SUBCD, &hauptadresse, &b.HausnrGebaeudeBez, &b.LatlongY, &b.LatlongX, &brw, &bhw, &bepsg); err != nil {
+			return nil, dbError("reading from database failed", err)
+		}
+		b.Hauptadresse = hauptadresse != nil && *hauptadresse == "1"
+		b.Coordinates = pointCoordinates(b.LatlongY, b.LatlongX, brw, bhw, bepsg)
+		d.Buildings = append(d.Buildings, b)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, dbError("reading from database failed", err)
+	}
+
+	d.complete(rw, hw, epsg)
+	return &d, nil
+}
+
+// History lists the versions of the address with the address code adrcd,
+// the oldest first
+func (pg *postgisStore) History(ctx context.Context, adrcd string) ([]AddressVersion, error) {
+	rows, err := pg.QueryContext(ctx, historySQL, adrcd)
+	if err != nil {
+		return nil, dbError("database query failed", err)
+	}
+	defer rows.Close()
+
+	var versions []AddressVersion
+
+	for rows.Next() {
+		var v AddressVersion
+		if err = v.scan(rows, &v.ValidFrom, &v.ValidTo); err != nil {
+			return nil, dbError("reading from database failed", err)
+		}
+		versions = append(versions, v)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, dbError("reading from database failed", err)
+	}
+	return versions, nil
+}
+
+// Catalogue lists the entries of level within the entry parent of the
+// level above
+func (pg *postgisStore) Catalogue(ctx context.Context, level catalogueLevel, parent string) ([]CatalogueEntry, error) {
+	return pg.queryCatalogue(ctx, level, catalogueColumns[level][2], parent)
+}
+
+// queryCatalogue lists the entries of level whose column equals value, all
+// entries when value is empty
+func (pg *postgisStore) queryCatalogue(ctx context.Context, level catalogueLevel, column, value string) ([]CatalogueEntry, error) {
+	c := catalogueColumns[level]
+	rows, err := pg.QueryContext(ctx, fmt.Sprintf(catalogueSQL, c[0], c[1], column, columnType(column)), value)
+	if err != nil {
+		return nil, dbError("database query failed", err)
+	}
+	defer rows.Close()
+
+	entries := []CatalogueEntry{}
+
+	for rows.Next() {
+		var e CatalogueEntry
+		if err = rows.Scan(&e.Code, &e.Name, &e.Count, &e.LatlongX, &e.LatlongY); err != nil {
+			return nil, dbError("reading from database failed", err)
+		}
+		entries = append(entries, e)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, dbError("reading from database failed", err)
+	}
+	return entries, nil
+}
+
+// Postcode describes the area served by the postcode plz
+func (pg *postgisStore) Postcode(ctx context.Context, plz string) (*Postcode, error) {
+	p := &Postcode{PLZ: plz}
+	var xmin, ymin, xmax, ymax sql.NullFloat64
+	var area sql.NullString
+	err := pg.QueryRowContext(ctx, postcodeSQL, plz).Scan(&p.Count, &xmin, &ymin, &xmax, &ymax, &area)
+	if err != nil {
+		return nil, dbError("database query failed", err)
+	}
+	if p.Count == 0 {
+		return p, nil
+	}
+	if xmin.Valid {
+		p.BBox = []float64{xmin.Float64, ymin.Float64, xmax.Float64, ymax.Float64}
+	}
+	if area.Valid {
+		p.Area = json.RawMessage(area.String)
+	}
+
+	for _, l := range []struct {
+		level   catalogueLevel
+		entries *[]CatalogueEntry
+	}{{levelMunicipalities, &p.Municipalities}, {levelLocalities, &p.Localities}, {levelStreets, &p.Streets}} {
+		if *l.entries, err = pg.queryCatalogue(ctx, l.level, "addritems.plz", plz); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// Postcodes lists the postcodes of the entry code of level
+func (pg *postgisStore) Postcodes(ctx context.Context, level catalogueLevel, code string) ([]CatalogueEntry, error) {
+	return pg.queryCatalogue(ctx, levelPostcodes, catalogueColumns[level][0], code)
+}
+
+// HouseNumbers lists the addresses of the street skz, of the locality okz
+// only unless empty, ordered by house number
+func (pg *postgisStore) HouseNumbers(ctx context.Context, skz, okz string) ([]Address, error) {
+	return pg.queryAddresses(ctx, houseNumbersSQL, []interface{}{skz, okz})
+}
+
+// Dataset returns the Stichtag and load time of the most recent import
+func (pg *postgisStore) Dataset(ctx context.Context) (*Dataset, error) {
+	var d Dataset
+	var loaded time.Time
+	err := pg.QueryRowContext(ctx, datasetSQL).Scan(&d.Stichtag, &loaded)
+	if e, ok := err.(*pq.Error); ok && e.Code == "42P01" {
+		// undefined table: the database was not loaded by the import command
+		return &d, nil
+	}
+	switch {
+	case err == sql.ErrNoRows:
+		return &d, nil
+	case err != nil:
+		return nil, dbError("database query failed", err)
+	}
+	d.Loaded = &loaded
+	return &d, nil
+}
+
+// Changes calls fn for the changes described by p
+func (pg *postgisStore) Changes(ctx context.Context, p *changesParams, fn func(*Change) error) error {
+	var gone bool
+	if err := pg.QueryRowContext(ctx, changesGoneSQL, p.sinceDate, p.sinceTime, p.after).Scan(&gone); err != nil {
+		return dbError("database query failed", err)
+	}
+	if gone {
+		return errChangesGone
+	}
+
+	rows, err := pg.QueryContext(ctx, changesSQL, p.sinceDate, p.sinceTime, p.after, int64(p.n))
+	if err != nil {
+		return dbError("database query failed", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var c Change
+		var before, after Address
+		var hasBefore, hasAfter sql.NullBool
+
+		dest := []interface{}{&c.Seq, &c.Stichtag, &c.ADRCD, &c.Type, &hasBefore}
+		dest = append(dest, before.dest()...)
+		dest = append(dest, &hasAfter)
+		dest = append(dest, after.dest()...)
+		if err = rows.Scan(dest...); err != nil {
+			return dbError("reading from database failed", err)
+		}
+		if hasBefore.Valid {
+			before.derive()
+			c.Before = &before
+		}
+		if hasAfter.Valid {
+			after.derive()
+			c.After = &after
+		}
+		if err = fn(&c); err != nil {
+			return err
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return dbError("reading from database failed", err)
+	}
+	return nil
+}
+
+// schemaSQL tells whether the tables and extensions the queries depend on
+// are present
+const schemaSQL = `select to_regclass('adresse') is not null, to_regclass('addritems') is not null,
+exists(select 1 from pg_extension where extname = 'postgis'),
+(select count(*) = 4 from pg_attribute
+where attrelid in (to_regclass('adresse'), to_regclass('addritems'))
+and attname in ('valid_from', 'valid_to') and not attisdropped)`
+
+// readySQL tells whether the indexes of the full text and reverse search are
+// present, whether there are current addresses and whether pg_trgm is
+// installed
+const readySQL = `select exists(select 1 from pg_indexes where tablename = 'addritems' and indexdef like '%USING gin (search)%'),
+exists(select 1 from pg_indexes where tablename = 'adresse' and indexdef like '%USING gist (latlong_g)%'),
+exists(select 1 from addritems where valid_to is null),
+exists(select 1 from pg_extension where extname = 'pg_trgm')`
+
+const addressCountSQL = `select count(*) from addritems where valid_to is null`
+
+const buildingCountSQL = `select count(*) from gebaeude where valid_to is null`
+
+// checkSchema returns an error when the database cannot be reached or lacks
+// the tables or extensions the queries depend on
+func (pg *postgisStore) checkSchema(ctx context.Context) error {
+	if err := pg.PingContext(ctx); err != nil {
+		return errors.New("database unreachable: " + err.Error())
+	}
+
+	var adresse, addritems, postgis, versioned bool
+	if err := pg.QueryRowContext(ctx, schemaSQL).Scan(&adresse, &addritems, &postgis, &versioned); err != nil {
+		return dbError("database query failed", err)
+	}
+	switch {
+	case !postgis:
+		return errors.New("extension postgis is not installed")
+	case !adresse || !addritems:
+		return errors.New("tables adresse and addritems are missing, load a release using bevaddressapi import")
+	case !versioned:
+		// loaded by the former bevaddress-dataload scripts
+		return errors.New("tables adresse and addritems lack the columns valid_from and valid_to, reload the release using bevaddressapi import")
+	}
+	return nil
+}
+
+// trigramSQL tells whether the extension pg_trgm is installed
+const trigramSQL = `select exists(select 1 from pg_extension where extname = 'pg_trgm')`
+
+// detectTrigram enables the fuzzy search tier when the extension pg_trgm is
+// installed. Without it, searches do without the tier instead of failing.
+func (pg *postgisStore) detectTrigram(ctx context.Context) error {
+	if err := pg.QueryRowContext(ctx, trigramSQL).Scan(&pg.trigram); err != nil {
+		return dbError("database query failed", err)
+	}
+	if !pg.trigram {
+		info("extension pg_trgm is not installed, the fuzzy search tier is disabled")
+	}
+	return nil
+}
+
+// Ready checks the schema, the search indexes, that a release is loaded and
+// that pg_trgm is still installed when the fuzzy search tier is enabled
+func (pg *postgisStore) Ready(ctx context.Context) error {
+	if err := pg.checkSchema(ctx); err != nil {
+		return err
+	}
+
+	var fts, geo, loaded, trigram bool
+	if err := pg.QueryRowContext(ctx, readySQL).Scan(&fts, &geo, &loaded, &trigram); err != nil {
+		return dbError("database query failed", err)
+	}
+	switch {
+	case pg.trigram && !trigram:
+		return errors.New("extension pg_trgm was removed, the fuzzy search tier fails")
+	case !fts:
+		return errors.New("the full text search index on addritems is missing")
+	case !geo:
+		return errors.New("the spatial index on adresse is missing")
+	case !loaded:
+		return errors.New("no addresses loaded")
+	}
+	return nil
+}
+
+// Counts returns the number of current addresses and buildings. Buildings
+// are counted only when the release was loaded by the import command.
+func (pg *postgisStore) Counts(ctx context.Context) (*RowCounts, error) {
+	var c RowCounts
+	if err := pg.QueryRowContext(ctx, addressCountSQL).Scan(&c.Addresses); err != nil {
+		return nil, dbError("database query failed", err)
+	}
+	err := pg.QueryRowContext(ctx, buildingCountSQL).Scan(&c.Buildings)
+	if e, ok := err.(*pq.Error); ok && e.Code == "42P01" {
+		// undefined table: the database was not loaded by the import command
+		return &c, nil
+	}
+	if err != nil {
+		return nil, dbError("database query failed", err)
+	}
+	return &c, nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const cacheMaxAge = 3600 // seconds clients and proxies may cache a pinned REST response, see cachePinned
+
+// restFulltextSearch serves the full text search as plain HTTP/JSON. It takes
+// the same parameters as the websocket endpoint.
+func (con *connection) restFulltextSearch(w http.ResponseWriter, r *http.Request) {
+	p, err := parseFTSParams(r.URL.Query())
+	if err != nil {
+		httpError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if p.q == "" && !p.structured() {
+		httpError(w, "parameter q or a structured query is required", http.StatusBadRequest)
+		return
+	}
+
+	addresses, err := con.store.Search(r.Context(), p)
+	if err != nil {
+		storeError(w, err)
+		return
+	}
+	con.cachePinned(w, r, p.asof)
+	writeAddresses(w, r, p.format, addresses)
+}
+
+// cachePinned makes the response cacheable for cacheMaxAge when asof pins it
+// to a date before the Stichtag of the loaded release. An import only adds
+// releases with a later Stichtag, so the response does not change.
+func (con *connection) cachePinned(w http.ResponseWriter, r *http.Request, asof string) {
+	if asof == "" {
+		return
+	}
+	d, err := con.store.Dataset(r.Context())
+	if err != nil || d == nil || d.Stichtag == "" || asof >= d.Stichtag {
+		return
+	}
+	w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(cacheMaxAge))
+}
+
+// writeJSON encodes v as the response body, by default with content type
+// application/json and to be revalidated by caches, as an import changes the
+// results. The response carries an ETag derived from the body, so a matching
+// If-None-Match is answered with 304 Not Modified.
+func writeJSON(w http.ResponseWriter, r *http.Request, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		httpError(w, "encoding response failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	etag := fmt.Sprintf(`"%x"`, sha1.Sum(body))
+	h := w.Header()
+	h.Set("ETag", etag)
+	if h.Get("Cache-Control") == "" {
+		h.Set("Cache-Control", "no-cache")
+	}
+
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	if h.Get("Content-Type") == "" {
+		h.Set("Content-Type", "application/json; charset=utf-8")
+	}
+	w.Write(body)
+}
+
+// etagMatches reports whether the If-None-Match header value inm lists etag
+func etagMatches(inm, etag string) bool {
+	for _, candidate := range strings.Split(inm, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// storeError sends the error err of the AddressStore to the client, telling a
+// query which timed out or was cancelled apart from other failures
+func storeError(w http.ResponseWriter, err error) {
+	switch err {
+	case errQueryTimeout:
+		httpError(w, err.Error(), http.StatusGatewayTimeout)
+	case errQueryCancelled:
+		// the client went away or the server shuts down
+		httpError(w, err.Error(), http.StatusServiceUnavailable)
+	default:
+		httpError(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// httpError logs s and sends it to the client with the HTTP status code
+func httpError(w http.ResponseWriter, s string, code int) {
+	info(s)
+	http.Error(w, s, code)
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// fakeStore answers the handlers with canned results. Methods without a
+// function set panic by calling the nil AddressStore.
+type fakeStore struct {
+	AddressStore
+	search  func(ctx context.Context, p *ftsParams) ([]Address, error)
+	reverse func(p *reverseParams) ([]Address, error)
+	detail  func(adrcd, asof string) (*AddressDetail, error)
+	changes func(p *changesParams, fn func(*Change) error) error
+}
+
+func (f *fakeStore) Search(ctx context.Context, p *ftsParams) ([]Address, error) {
+	return f.search(ctx, p)
+}
+
+func (f *fakeStore) Reverse(ctx context.Context, p *reverseParams) ([]Address, error) {
+	return f.reverse(p)
+}
+
+func (f *fakeStore) Detail(ctx context.Context, adrcd, asof string) (*AddressDetail, error) {
+	return f.detail(adrcd, asof)
+}
+
+func (f *fakeStore) Changes(ctx context.Context, p *changesParams, fn func(*Change) error) error {
+	return f.changes(p, fn)
+}
+
+func (f *fakeStore) Dataset(ctx context.Context) (*Dataset, error) {
+	return &Dataset{Stichtag: "2026-04-01"}, nil
+}
+
+func str(s string) *string { return &s }
+
+var testAddress = Address{ADRCD: str("1"), PLZ: str("1010"), Gemeindename: str("Wien"), Strassenname: str("Akademiestraße"), Hausnr: str("2"), HausnrAnzeige: "2"}
+
+// serve runs handler for a request of method to target with body and
+// mux variables vars
+func serve(handler http.HandlerFunc, method, target, body string, vars map[string]string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	if method == "POST" {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	if vars != nil {
+		r = mux.SetURLVars(r, vars)
+	}
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
+
+func TestRestFulltextSearch(t *testing.T) {
+	var got *ftsParams
+	con := &connection{store: &fakeStore{search: func(ctx context.Context, p *ftsParams) ([]Address, error) {
+		got = p
+		if p.q == "timeout" {
+			return nil, errQueryTimeout
+		}
+		return []Address{testAddress}, nil
+	}}}
+
+	tests := []struct {
+		target string
+		code   int
+	}{
+		{"/api/address/fts?q=Akademiestraße+2", http.StatusOK},
+		{"/api/address/fts?q=Akademiestraße+2&n=5&postcode=1010", http.StatusOK},
+		{"/api/address/fts", http.StatusBadRequest},
+		{"/api/address/fts?q=x&n=abc", http.StatusBadRequest},
+		{"/api/address/fts?q=x&n=250", http.StatusBadRequest},
+		{"/api/address/fts?q=x&lat=48.2", http.StatusBadRequest},
+		{"/api/address/fts?q=x&lat=21474836.475&lon=0", http.StatusBadRequest},
+		{"/api/address/fts?q=x&lat=NaN&lon=16", http.StatusBadRequest},
+		{"/api/address/fts?q=x&postcode=abc", http.StatusBadRequest},
+		{"/api/address/fts?q=x&asof=yesterday", http.StatusBadRequest},
+		{"/api/address/fts?q=x&format=xml", http.StatusBadRequest},
+		{"/api/address/fts?q=timeout", http.StatusGatewayTimeout},
+	}
+	for _, tt := range tests {
+		if w := serve(con.restFulltextSearch, "GET", tt.target, "", nil); w.Code != tt.code {
+			t.Errorf("%s: status %d, want %d: %s", tt.target, w.Code, tt.code, w.Body)
+		}
+	}
+
+	w := serve(con.restFulltextSearch, "GET", "/api/address/fts?q=Akademiestraße+2&n=5", "", nil)
+	var addresses []Address
+	if err := json.Unmarshal(w.Body.Bytes(), &addresses); err != nil {
+		t.Fatal(err)
+	}
+	if len(addresses) != 1 || *addresses[0].ADRCD != "1" || addresses[0].HausnrAnzeige != "2" {
+		t.Errorf("got %s", w.Body)
+	}
+	if got.n != 5 || got.q != "Akademiestraße 2" {
+		t.Errorf("store got n = %d, q = %q", got.n, got.q)
+	}
+	if w.Header().Get("ETag") == "" || w.Header().Get("Cache-Control") != "no-cache" {
+		t.Errorf("headers %v", w.Header())
+	}
+
+	w = serve(con.restFulltextSearch, "GET", "/api/address/fts?q=x&format=geojson", "", nil)
+	var fc struct {
+		Type     string
+		Features []json.RawMessage
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &fc); err != nil || fc.Type != "FeatureCollection" || len(fc.Features) != 1 {
+		t.Errorf("geojson: got %s", w.Body)
+	}
+}
+
+func TestReverseSearch(t *testing.T) {
+	con := &connection{store: &fakeStore{reverse: func(p *reverseParams) ([]Address, error) {
+		if p.radius == 999 {
+			return nil, errQueryTimeout
+		}
+		a := testAddress
+		d := 12.5
+		a.Distance = &d
+		return []Address{a}, nil
+	}}}
+
+	tests := []struct {
+		target string
+		code   int
+	}{
+		{"/api/address/reverse?lat=48.2&lon=16.37", http.StatusOK},
+		{"/api/address/reverse?lat=48.2&lon=16.37&radius=500&n=10", http.StatusOK},
+		{"/api/address/reverse?lat=48.2", http.StatusBadRequest},
+		{"/api/address/reverse?lat=91&lon=16", http.StatusBadRequest},
+		{"/api/address/reverse?lat=NaN&lon=16", http.StatusBadRequest},
+		{"/api/address/reverse?lat=48&lon=NaN", http.StatusBadRequest},
+		{"/api/address/reverse?lat=48&lon=16&radius=NaN", http.StatusBadRequest},
+		{"/api/address/reverse?lat=48&lon=16&radius=5000", http.StatusBadRequest},
+		{"/api/address/reverse?lat=48&lon=16&n=51", http.StatusBadRequest},
+		{"/api/address/reverse?lat=48&lon=16&radius=999", http.StatusGatewayTimeout},
+	}
+	for _, tt := range tests {
+		if w := serve(con.reverseSearch, "GET", tt.target, "", nil); w.Code != tt.code {
+			t.Errorf("%s: status %d, want %d: %s", tt.target, w.Code, tt.code, w.Body)
+		}
+	}
+
+	w := serve(con.reverseSearch, "GET", "/api/address/reverse?lat=48.2&lon=16.37", "", nil)
+	var addresses []Address
+	if err := json.Unmarshal(w.Body.Bytes(), &addresses); err != nil {
+		t.Fatal(err)
+	}
+	if len(addresses) != 1 || addresses[0].Distance == nil || *addresses[0].Distance != 12.5 {
+		t.Errorf("got %s", w.Body)
+	}
+}
+
+func TestBatchSearch(t *testing.T) {
+	con := &connection{store: &fakeStore{search: func(ctx context.Context, p *ftsParams) ([]Address, error) {
+		switch p.q {
+		case "timeout":
+			return nil, errQueryTimeout
+		case "nothing":
+			return nil, nil
+		}
+		a := testAddress
+		score := 0.8
+		a.Score = &score
+		return []Address{a}, nil
+	}}}
+
+	w := serve(con.batchSearch, "POST", "/api/address/batch", `[{"id":"a","q":"Akademiestraße 2"},{"id":"b","q":"nothing"},{"id":"c","q":"timeout"},{"id":"d","q":"x","postcode":"abc"}]`, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	var results []struct {
+		ID      string
+		Address *Address
+		Score   float64
+		Error   string
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &results); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 4 {
+		t.Fatalf("got %s", w.Body)
+	}
+	if r := results[0]; r.ID != "a" || r.Address == nil || *r.Address.ADRCD != "1" || r.Score != 0.8 {
+		t.Errorf("line a: %+v", r)
+	}
+	if r := results[1]; r.ID != "b" || r.Address != nil || r.Error != "" {
+		t.Errorf("line b: %+v", r)
+	}
+	if r := results[2]; r.ID != "c" || r.Error != errQueryTimeout.Error() {
+		t.Errorf("line c: %+v", r)
+	}
+	if r := results[3]; r.ID != "d" || r.Error == "" {
+		t.Errorf("line d: %+v", r)
+	}
+
+	if w := serve(con.batchSearch, "POST", "/api/address/batch", `{"q":`, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("malformed body: status %d", w.Code)
+	}
+	r := httptest.NewRequest("POST", "/api/address/batch", strings.NewReader("x"))
+	r.Header.Set("Content-Type", "text/plain")
+	w = httptest.NewRecorder()
+	con.batchSearch(w, r)
+	if w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("text/plain: status %d", w.Code)
+	}
+}
+
+func TestAddressDetail(t *testing.T) {
+	con := &connection{store: &fakeStore{detail: func(adrcd, asof string) (*AddressDetail, error) {
+		switch adrcd {
+		case "1":
+			return &AddressDetail{Address: testAddress, Bundesland: "9", Buildings: []Building{{SUBCD: "001"}}}, nil
+		case "2":
+			return nil, errQueryTimeout
+		}
+		return nil, nil
+	}}}
+
+	tests := []struct {
+		target string
+		vars   map[string]string
+		code   int
+	}{
+		{"/api/address/1", map[string]string{"adrcd": "1"}, http.StatusOK},
+		{"/api/address/1/001", map[string]string{"adrcd": "1", "subcd": "001"}, http.StatusOK},
+		{"/api/address/1/002", map[string]string{"adrcd": "1", "subcd": "002"}, http.StatusNotFound},
+		{"/api/address/3", map[string]string{"adrcd": "3"}, http.StatusNotFound},
+		{"/api/address/1?asof=1.1.2020", map[string]string{"adrcd": "1"}, http.StatusBadRequest},
+		{"/api/address/2", map[string]string{"adrcd": "2"}, http.StatusGatewayTimeout},
+	}
+	for _, tt := range tests {
+		if w := serve(con.addressDetail, "GET", tt.target, "", tt.vars); w.Code != tt.code {
+			t.Errorf("%s: status %d, want %d: %s", tt.target, w.Code, tt.code, w.Body)
+		}
+	}
+
+	w := serve(con.addressDetail, "GET", "/api/address/1", "", map[string]string{"adrcd": "1"})
+	var d AddressDetail
+	if err := json.Unmarshal(w.Body.Bytes(), &d); err != nil {
+		t.Fatal(err)
+	}
+	if *d.ADRCD != "1" || d.Bundesland != "9" || len(d.Buildings) != 1 {
+		t.Errorf("got %s", w.Body)
+	}
+	if cc := w.Header().Get("Cache-Control"); cc != "no-cache" {
+		t.Errorf("Cache-Control %q, want no-cache", cc)
+	}
+
+	// a record as of a date before the loaded release does not change
+	w = serve(con.addressDetail, "GET", "/api/address/1?asof=2020-01-01", "", map[string]string{"adrcd": "1"})
+	if cc := w.Header().Get("Cache-Control"); !strings.HasPrefix(cc, "public, max-age=") {
+		t.Errorf("asof: Cache-Control %q, want public", cc)
+	}
+}
+
+func TestStreamChanges(t *testing.T) {
+	// 2500 changes, the query of the third page fails
+	var pages []int64
+	con := &connection{store: &fakeStore{changes: func(p *changesParams, fn func(*Change) error) error {
+		pages = append(pages, p.after)
+		if p.after >= 2000 {
+			return errQueryTimeout
+		}
+		for seq := p.after + 1; seq <= p.after+int64(p.n) && seq <= 2500; seq++ {
+			if err := fn(&Change{Seq: seq, ADRCD: "1", Type: "modified"}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}}}
+
+	w := serve(con.changeFeed, "GET", "/api/address/changes?format=ndjson", "", nil)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/x-ndjson" {
+		t.Fatalf("status %d, content type %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	if len(lines) != 2001 {
+		t.Fatalf("got %d lines, want 2000 changes and the error", len(lines))
+	}
+	if last := lines[len(lines)-1]; last != `{"error":"query timed out"}` {
+		t.Errorf("last line %s", last)
+	}
+	if len(pages) != 3 || pages[1] != 1000 || pages[2] != 2000 {
+		t.Errorf("pages after %v", pages)
+	}
+
+	// an error before the first change is reported by the status
+	pages = nil
+	w = serve(con.changeFeed, "GET", "/api/address/changes?format=ndjson&after=2000", "", nil)
+	if w.Code != http.StatusGatewayTimeout {
+		t.Errorf("status %d, want %d", w.Code, http.StatusGatewayTimeout)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+const maxrowsReverse = 50
+const defaultrowsReverse = 1
+const maxradiusReverse = 2000    // hard limit for the search radius of a reverse search in meter
+const defaultradiusReverse = 100 // default search radius of a reverse search in meter
+
+// reverseParams holds the validated parameters of a reverse search
+type reverseParams struct {
+	lat, lon, radius float64
+	n                uint64
+	format           outputFormat
+}
+
+func parseReverseParams(v url.Values) (*reverseParams, error) {
+	p := &reverseParams{radius: defaultradiusReverse, n: defaultrowsReverse}
+
+	var err error
+	if p.format, err = parseFormat(v.Get("format")); err != nil {
+		return nil, err
+	}
+
+	if p.lat, err = strconv.ParseFloat(v.Get("lat"), 64); err != nil || math.IsNaN(p.lat) || p.lat < -90 || p.lat > 90 {
+		return nil, errors.New("parameter lat is required and has to be a latitude between -90 and 90")
+	}
+	if p.lon, err = strconv.ParseFloat(v.Get("lon"), 64); err != nil || math.IsNaN(p.lon) || p.lon < -180 || p.lon > 180 {
+		return nil, errors.New("parameter lon is required and has to be a longitude between -180 and 180")
+	}
+
+	if radius := v.Get("radius"); radius != "" {
+		if p.radius, err = strconv.ParseFloat(radius, 64); err != nil {
+			return nil, errors.New("error when parsing parameter radius: " + err.Error())
+		}
+		if math.IsNaN(p.radius) || p.radius <= 0 || p.radius > maxradiusReverse {
+			return nil, errors.New("parameter radius out of range")
+		}
+	}
+
+	if nrows := v.Get("n"); nrows != "" {
+		if p.n, err = strconv.ParseUint(nrows, 10, 8); err != nil {
+			return nil, errors.New("error when parsing parameter n: " + err.Error())
+		}
+		if p.n > maxrowsReverse {
+			return nil, errors.New("parameter n has to be between 0 and " + strconv.Itoa(maxrowsReverse))
+		}
+	}
+	return p, nil
+}
+
+// reverseSearch serves the reverse geocoding endpoint
+func (con *connection) reverseSearch(w http.ResponseWriter, r *http.Request) {
+	p, err := parseReverseParams(r.URL.Query())
+	if err != nil {
+		httpError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	addresses, err := con.store.Reverse(r.Context(), p)
+	if err != nil {
+		storeError(w, err)
+		return
+	}
+	writeAddresses(w, r, p.format, addresses)
+}
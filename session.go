@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const sessionReadLimit = 4096             // maximum size of a single query message in bytes
+const sessionWriteWait = 10 * time.Second // time allowed to write a result message to the client
+
+// ftsMessage is a query sent by the client over an open search session
+type ftsMessage struct {
+	ID           json.RawMessage `json:"id,omitempty"`
+	Q            string          `json:"q"`
+	Street       string          `json:"street,omitempty"`
+	Housenumber  string          `json:"housenumber,omitempty"`
+	City         string          `json:"city,omitempty"`
+	Locality     string          `json:"locality,omitempty"`
+	Asof         string          `json:"asof,omitempty"`
+	Autocomplete *bool           `json:"autocomplete,omitempty"`
+	Fuzzy        *bool           `json:"fuzzy,omitempty"`
+	Postcode     string          `json:"postcode,omitempty"`
+	Citycode     string          `json:"citycode,omitempty"`
+	Province     string          `json:"province,omitempty"`
+	District     string          `json:"district,omitempty"`
+	Lat          *float64        `json:"lat,omitempty"`
+	Lon          *float64        `json:"lon,omitempty"`
+	N            *uint64         `json:"n,omitempty"`
+	Format       string          `json:"format,omitempty"`
+}
+
+// ftsResult is the answer to an ftsMessage, tagged with the id the client
+// chose for the query
+type ftsResult struct {
+	ID        json.RawMessage `json:"id,omitempty"`
+	Addresses interface{}     `json:"addresses"` // []Address or a GeoJSON FeatureCollection
+	Error     string          `json:"error,omitempty"`
+}
+
+func (m *ftsMessage) params() (*ftsParams, error) {
+	p := &ftsParams{
+		q:            m.Q,
+		postcode:     m.Postcode,
+		citycode:     m.Citycode,
+		province:     m.Province,
+		district:     m.District,
+		street:       m.Street,
+		housenumber:  m.Housenumber,
+		city:         m.City,
+		locality:     m.Locality,
+		asof:         m.Asof,
+		autocomplete: m.Autocomplete == nil || *m.Autocomplete,
+		n:            defaultrowsFTS,
+	}
+	if m.Fuzzy != nil {
+		if *m.Fuzzy {
+			p.fuzzy = fuzzyAlways
+		} else {
+			p.fuzzy = fuzzyNever
+		}
+	}
+
+	var err error
+	if p.format, err = parseFormat(m.Format); err != nil {
+		return nil, err
+	}
+	if m.Lat != nil {
+		p.lat = strconv.FormatFloat(*m.Lat, 'f', -1, 64)
+	}
+	if m.Lon != nil {
+		p.lon = strconv.FormatFloat(*m.Lon, 'f', -1, 64)
+	}
+	if m.N != nil {
+		p.n = *m.N
+	}
+
+	if err = p.validate(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// ftsSession keeps a websocket open for many queries. Only the most recent
+// query of a session is executed: a new message cancels the query still in
+// flight and the result of a superseded query is never sent.
+type ftsSession struct {
+	con *connection
+	ws  *websocket.Conn
+
+	wmu sync.Mutex // serialises writes to ws
+
+	mu      sync.Mutex
+	seq     uint64             // sequence number of the most recent query
+	cancel  context.CancelFunc // cancels the most recent query
+	closing bool               // the server shuts down, no more queries are run
+	queries sync.WaitGroup     // queries in flight
+}
+
+func (con *connection) fulltextSearchSession(w http.ResponseWriter, r *http.Request) {
+	conn := con.upgrade(w, r)
+	if conn == nil {
+		return
+	}
+
+	s := &ftsSession{con: con, ws: conn}
+	s.serve(r.Context())
+}
+
+func (s *ftsSession) serve(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer func() {
+		cancel()
+		s.queries.Wait()
+		s.ws.Close()
+	}()
+	go s.drain(ctx)
+
+	s.ws.SetReadLimit(sessionReadLimit)
+	for {
+		_, data, err := s.ws.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				info("search session terminated: " + err.Error())
+			}
+			return
+		}
+
+		var msg ftsMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			s.reply(0, ftsResult{Error: "malformed query message: " + err.Error()})
+			continue
+		}
+
+		qctx, seq, ok := s.supersede(ctx)
+		if !ok {
+			continue
+		}
+		go func() {
+			defer s.queries.Done()
+			s.run(qctx, seq, &msg)
+		}()
+	}
+}
+
+// supersede cancels the query in flight and returns the context and sequence
+// number for the next query. It reports false when the server shuts down and
+// the query must not be run.
+func (s *ftsSession) supersede(ctx context.Context) (context.Context, uint64, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closing {
+		return nil, 0, false
+	}
+	if s.cancel != nil {
+		s.cancel()
+	}
+	ctx, s.cancel = context.WithCancel(ctx)
+	s.seq++
+	s.queries.Add(1)
+	return ctx, s.seq, true
+}
+
+// drain closes the session when the server shuts down: the query in flight
+// is answered, then the client is sent a close frame. The connection is
+// closed when the client does not acknowledge in time or when the grace
+// period of the shutdown is over, which cancels ctx.
+func (s *ftsSession) drain(ctx context.Context) {
+	select {
+	case <-s.con.draining:
+	case <-ctx.Done():
+		return
+	}
+
+	s.mu.Lock()
+	s.closing = true
+	s.mu.Unlock()
+	s.queries.Wait()
+
+	s.wmu.Lock()
+	closeGoingAway(s.ws)
+	s.wmu.Unlock()
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(sessionWriteWait):
+	}
+	s.ws.Close()
+}
+
+func (s *ftsSession) run(ctx context.Context, seq uint64, msg *ftsMessage) {
+	result := ftsResult{ID: msg.ID}
+
+	var addresses []Address
+	p, err := msg.params()
+	if err == nil {
+		addresses, err = s.con.store.Search(ctx, p)
+	}
+	if ctx.Err() != nil {
+		return
+	}
+	if err != nil {
+		info(err.Error())
+		result.Error = err.Error()
+	} else {
+		if addresses == nil {
+			addresses = []Address{}
+		}
+		result.Addresses = p.format.shape(addresses)
+	}
+	s.reply(seq, result)
+}
+
+// reply sends result to the client unless the query with sequence number seq
+// has been superseded in the meantime. Replies which do not belong to a query
+// are passed with seq 0 and always sent.
+func (s *ftsSession) reply(seq uint64, result ftsResult) {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+
+	if seq != 0 {
+		s.mu.Lock()
+		current := seq == s.seq
+		s.mu.Unlock()
+		if !current {
+			return
+		}
+	}
+
+	s.ws.SetWriteDeadline(time.Now().Add(sessionWriteWait))
+	if err := s.ws.WriteJSON(result); err != nil {
+		info("writing search result failed: " + err.Error())
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// dialSession opens a search session with con
+func dialSession(t *testing.T, con *connection) *websocket.Conn {
+	ts := httptest.NewServer(http.HandlerFunc(con.fulltextSearch))
+	t.Cleanup(ts.Close)
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ws.Close() })
+	return ws
+}
+
+// readResult reads the next result of the session
+func readResult(t *testing.T, ws *websocket.Conn) ftsResult {
+	t.Helper()
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var result ftsResult
+	if err := ws.ReadJSON(&result); err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestSearchSessionSupersede(t *testing.T) {
+	started := make(chan string, 2)
+	cancelled := make(chan struct{})
+	con := &connection{draining: make(chan struct{}), store: &fakeStore{search: func(ctx context.Context, p *ftsParams) ([]Address, error) {
+		started <- p.q
+		if p.q == "slow" {
+			<-ctx.Done()
+			close(cancelled)
+			return nil, contextError(ctx.Err())
+		}
+		return []Address{testAddress}, nil
+	}}}
+	ws := dialSession(t, con)
+
+	if err := ws.WriteMessage(websocket.TextMessage, []byte(`{"id": 1, "q": "slow"}`)); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	if err := ws.WriteMessage(websocket.TextMessage, []byte(`{"id": 2, "q": "fast"}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	result := readResult(t, ws)
+	if string(result.ID) != "2" || result.Error != "" {
+		t.Errorf("got result %s %q, want the result of query 2", result.ID, result.Error)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("query 1 was not cancelled")
+	}
+
+	ws.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if _, data, err := ws.ReadMessage(); err == nil {
+		t.Errorf("got %s, want no reply to the superseded query 1", data)
+	}
+}
+
+func TestSearchSessionDrain(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	con := &connection{draining: make(chan struct{}), store: &fakeStore{search: func(ctx context.Context, p *ftsParams) ([]Address, error) {
+		close(started)
+		<-release
+		return []Address{testAddress}, nil
+	}}}
+	ws := dialSession(t, con)
+
+	if err := ws.WriteMessage(websocket.TextMessage, []byte(`{"id": 1, "q": "Akademiestraße"}`)); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	close(con.draining)
+	close(release)
+
+	// the query in flight is answered before the session is closed
+	if result := readResult(t, ws); string(result.ID) != "1" || result.Error != "" {
+		t.Errorf("got result %s %q, want the result of query 1", result.ID, result.Error)
+	}
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, _, err := ws.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		t.Errorf("got %v, want close frame going away", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		con.sessions.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(sessionWriteWait + 5*time.Second):
+		t.Error("session still open")
+	}
+}
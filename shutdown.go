@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// closeGoingAway sends the close frame telling the client of ws that the
+// server shuts down
+func closeGoingAway(ws *websocket.Conn) {
+	msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+	if err := ws.WriteControl(websocket.CloseMessage, msg, time.Now().Add(sessionWriteWait)); err != nil {
+		info("sending close frame failed: " + err.Error())
+	}
+}
+
+// shutdown stops servers gracefully. They stop accepting connections and
+// websocket clients are sent close frames, while in-flight requests get the
+// grace period to complete. Then the running queries are cancelled by
+// cancelQueries, which has the database abort them, the requests get
+// sessionWriteWait to answer, and the remaining connections and the database
+// are closed.
+func (con *connection) shutdown(servers []*http.Server, grace time.Duration, cancelQueries context.CancelFunc) {
+	close(con.draining)
+
+	ctx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+
+	// the servers do not track websocket connections, the handlers do
+	sessions := make(chan struct{})
+	go func() {
+		con.sessions.Wait()
+		close(sessions)
+	}()
+	shutdownServers(ctx, servers)
+
+	// the servers may have run out of the grace period with no websocket
+	// connections open, so the grace period is checked rather than selected on
+	if ctx.Err() == nil {
+		select {
+		case <-sessions:
+		case <-ctx.Done():
+		}
+	}
+	if ctx.Err() != nil {
+		info("grace period over, cancelling running queries")
+		cancelQueries()
+		answered, cancel := context.WithTimeout(context.Background(), sessionWriteWait)
+		defer cancel()
+		shutdownServers(answered, servers)
+		for _, srv := range servers {
+			srv.Close()
+		}
+		select {
+		case <-sessions:
+		case <-time.After(sessionWriteWait):
+			info("websocket connections still open")
+		}
+	}
+
+	if con.db != nil {
+		if err := con.db.Close(); err != nil {
+			info("closing database failed: " + err.Error())
+		}
+	}
+	info("shut down")
+}
+
+// shutdownServers shuts down servers in parallel, waiting for their requests
+// until ctx is done
+func shutdownServers(ctx context.Context, servers []*http.Server) {
+	var wg sync.WaitGroup
+	for _, srv := range servers {
+		wg.Add(1)
+		go func(srv *http.Server) {
+			defer wg.Done()
+			if err := srv.Shutdown(ctx); err != nil {
+				info("shutting down server on " + srv.Addr + ": " + err.Error())
+			}
+		}(srv)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+// shutdownDuring runs handler for a request and shuts the server down with
+// grace while the request is in flight. It returns the status the client
+// received and how long the shutdown took.
+func shutdownDuring(t *testing.T, con *connection, grace time.Duration, handler http.HandlerFunc) (int, time.Duration) {
+	base, cancelQueries := context.WithCancel(context.Background())
+	defer cancelQueries()
+
+	started := make(chan struct{})
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		handler(w, r)
+	}))
+	ts.Config.BaseContext = func(net.Listener) context.Context { return base }
+	ts.Start()
+	defer ts.Close()
+
+	status := make(chan int, 1)
+	go func() {
+		resp, err := http.Get(ts.URL)
+		if err != nil {
+			t.Errorf("request failed: %v", err)
+			status <- 0
+			return
+		}
+		resp.Body.Close()
+		status <- resp.StatusCode
+	}()
+
+	<-started
+	start := time.Now()
+	con.shutdown([]*http.Server{ts.Config}, grace, cancelQueries)
+	took := time.Since(start)
+
+	select {
+	case code := <-status:
+		return code, took
+	case <-time.After(5 * time.Second):
+		cancelQueries() // let the handler return for the server to close
+		t.Fatal("no response after the shutdown")
+	}
+	return 0, took
+}
+
+func TestShutdownCancelsRequests(t *testing.T) {
+	con := &connection{draining: make(chan struct{})}
+	code, took := shutdownDuring(t, con, 100*time.Millisecond, func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		storeError(w, contextError(r.Context().Err()))
+	})
+	if code != http.StatusServiceUnavailable {
+		t.Errorf("got status %d, want %d", code, http.StatusServiceUnavailable)
+	}
+	if took > 5*time.Second {
+		t.Errorf("shutdown took %v", took)
+	}
+}
+
+// TestShutdownGraceExpired shuts down with the websocket connections closed
+// before the grace period runs out and a request still in flight, when both
+// the sessions and the grace period are over once the servers return. The
+// request is cancelled each time.
+func TestShutdownGraceExpired(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		con := &connection{draining: make(chan struct{})}
+		con.sessions.Add(1)
+		go func() {
+			<-con.draining
+			con.sessions.Done()
+		}()
+		code, took := shutdownDuring(t, con, 100*time.Millisecond, func(w http.ResponseWriter, r *http.Request) {
+			<-r.Context().Done()
+			storeError(w, contextError(r.Context().Err()))
+		})
+		if code != http.StatusServiceUnavailable || took > 5*time.Second {
+			t.Fatalf("run %d: got status %d after %v, want %d", i, code, took, http.StatusServiceUnavailable)
+		}
+	}
+}
+
+// TestShutdownCancelsQueries checks that the database aborts a query running
+// when the grace period is over, rather than the query running on after the
+// server went away
+func TestShutdownCancelsQueries(t *testing.T) {
+	dburl := os.Getenv(testDatabaseEnv)
+	if dburl == "" {
+		t.Skip(testDatabaseEnv + " not set")
+	}
+	db, err := sql.Open("postgres", dburl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check, err := sql.Open("postgres", dburl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer check.Close()
+
+	// a websocket connection closing before the grace period is over, as in
+	// TestShutdownGraceExpired
+	con := &connection{db: db, draining: make(chan struct{})}
+	con.sessions.Add(1)
+	go func() {
+		<-con.draining
+		con.sessions.Done()
+	}()
+	code, took := shutdownDuring(t, con, 500*time.Millisecond, func(w http.ResponseWriter, r *http.Request) {
+		if _, err := db.ExecContext(r.Context(), "select pg_sleep(60)"); err != nil {
+			storeError(w, dbError("sleeping", err))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	if code != http.StatusServiceUnavailable {
+		t.Errorf("got status %d, want %d", code, http.StatusServiceUnavailable)
+	}
+	if took > 5*time.Second {
+		t.Errorf("shutdown took %v", took)
+	}
+
+	var running int
+	err = check.QueryRow(`select count(*) from pg_stat_activity where query = 'select pg_sleep(60)' and state = 'active'`).Scan(&running)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if running != 0 {
+		t.Errorf("pg_sleep still running after the shutdown")
+	}
+}
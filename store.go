@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// errQueryTimeout is returned when a query ran longer than the statement
+// timeout
+var errQueryTimeout = errors.New("query timed out")
+
+// errQueryCancelled is returned when the context of a query was cancelled,
+// as the client went away or the server shuts down
+var errQueryCancelled = errors.New("query cancelled")
+
+// contextError returns errQueryTimeout or errQueryCancelled when err tells
+// that the context of a query ended, otherwise err
+func contextError(err error) error {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return errQueryTimeout
+	case errors.Is(err, context.Canceled):
+		return errQueryCancelled
+	}
+	return err
+}
+
+// AddressStore is the backend the handlers retrieve addresses from
+type AddressStore interface {
+	// Search runs a full text search, possibly followed by the fuzzy tier
+	Search(ctx context.Context, p *ftsParams) ([]Address, error)
+	// Reverse returns the addresses nearest to a point, ordered by distance
+	Reverse(ctx context.Context, p *reverseParams) ([]Address, error)
+	// Detail returns the full record of the address with the BEV address
+	// code adrcd as it was at the date asof (YYYY-MM-DD), as it is now when
+	// asof is empty. It returns nil if there is no such address.
+	Detail(ctx context.Context, adrcd, asof string) (*AddressDetail, error)
+	// History lists the versions of the address with the BEV address code
+	// adrcd, the oldest first
+	History(ctx context.Context, adrcd string) ([]AddressVersion, error)
+	// Catalogue lists the entries of level within the entry with the code
+	// parent of the level above, ordered by name. Parent is empty for the
+	// provinces.
+	Catalogue(ctx context.Context, level catalogueLevel, parent string) ([]CatalogueEntry, error)
+	// HouseNumbers lists the addresses of the street skz, of the locality
+	// okz only unless empty, ordered by house number
+	HouseNumbers(ctx context.Context, skz, okz string) ([]Address, error)
+	// Postcode describes the area served by the postcode plz
+	Postcode(ctx context.Context, plz string) (*Postcode, error)
+	// Postcodes lists the postcodes of the entry code of level, a
+	// municipality or a locality
+	Postcodes(ctx context.Context, level catalogueLevel, code string) ([]CatalogueEntry, error)
+	// Dataset describes the loaded release
+	Dataset(ctx context.Context) (*Dataset, error)
+	// Changes calls fn for the changes described by p, in the order of the
+	// feed. It returns errChangesGone when they cannot be listed.
+	Changes(ctx context.Context, p *changesParams, fn func(*Change) error) error
+	// Ready returns an error describing why the store cannot serve requests,
+	// nil if it can
+	Ready(ctx context.Context) error
+	// Counts returns the number of current addresses and buildings
+	Counts(ctx context.Context) (*RowCounts, error)
+}
+
+// AddressVersion is an address as it was between ValidFrom and ValidTo, the
+// Stichtag of the releases which introduced and replaced it. ValidTo is nil
+// for the current version.
+type AddressVersion struct {
+	Address
+	ValidFrom string
+	ValidTo   *string
+}
+
+// RowCounts are the number of current records of the loaded release
+type RowCounts struct {
+	Addresses, Buildings int64
+}
+
+// Dataset describes the loaded release of the BEV Adressregister. Both
+// members are empty when the release was not loaded by bevaddressapi.
+type Dataset struct {
+	Stichtag string     `json:",omitempty"` // reference date of the release, YYYY-MM-DD
+	Loaded   *time.Time `json:",omitempty"` // when the release was loaded
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"database/sql"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testDatabaseEnv names the environment variable of a scratch database the
+// store tests also run against. The test release replaces its contents.
+const testDatabaseEnv = "BEVADDRESS_TEST_DATABASE_URL"
+
+// testRelease is a small BEV Adressregister release: two addresses in Vienna,
+// one with two buildings, and one in Innsbruck
+var testRelease = map[string]string{
+	"Gemeinde.csv":  "GKZ;GEMEINDENAME\n90101;Wien\n70101;Innsbruck\n",
+	"Ortschaft.csv": "GKZ;OKZ;ORTSNAME\n90101;17224;Wien,Innere Stadt\n70101;05001;Innsbruck\n",
+	"Strasse.csv":   "SKZ;STRASSENNAME;STRASSENNAMENZUSATZ;GKZ\n001;Akademiestraße;;90101\n002;Maria-Theresien-Straße;;70101\n",
+	"Adresse.csv": "ADRCD;GKZ;OKZ;PLZ;SKZ;ZUSTELLBEZIRK;HAUSNRTEXT;HAUSNRZAHL1;HAUSNRBUCHSTABE1;HAUSNRVERBINDUNG1;HAUSNRZAHL2;HAUSNRBUCHSTABE2;HAUSNRBEREICH;GNRADRESSE;HOFNAME;RW;HW;EPSG;QUELLADRESSE;BESTIMMUNGSART\n" +
+		"1;90101;17224;1010;001;;;2;;;;;;;;2381.1;340646.5;31256;;\n" +
+		"2;90101;17224;1010;001;;;3;a;;;;;;;2390.0;340660.0;31256;;\n" +
+		"3;70101;05001;6020;002;;;18;;;;;;;;-4600;237450;31254;;\n",
+	"Gebaeude.csv": "ADRCD;SUBCD;HAUPTADRESSE;HAUSNRGEBAEUDEBEZ;RW;HW;EPSG;QUELLADRESSE;BESTIMMUNGSART\n" +
+		"1;001;1;;2385.0;340650.0;31256;;\n" +
+		"1;002;0;Hof;2389.0;340655.0;31256;;\n",
+}
+
+// writeTestRelease writes the release files as ZIP into dir
+func writeTestRelease(t *testing.T, dir string, files map[string]string) string {
+	name := filepath.Join(dir, "release.zip")
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+	for file, content := range files {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: file, Method: zip.Deflate, Modified: time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+// testStores returns the backends loaded with testRelease: the memory
+// backend and, when testDatabaseEnv is set, PostGIS
+func testStores(t *testing.T) map[string]AddressStore {
+	release := writeTestRelease(t, t.TempDir(), testRelease)
+
+	mem, err := loadMemStore(release)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stores := map[string]AddressStore{"memory": mem}
+
+	if dburl := os.Getenv(testDatabaseEnv); dburl != "" {
+		db, err := sql.Open("postgres", dburl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Close() })
+		if _, err := importRelease(db, release, time.Time{}, false); err != nil {
+			t.Fatal(err)
+		}
+		pg := &postgisStore{DB: db}
+		if err := pg.detectTrigram(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		stores["postgis"] = pg
+	}
+	return stores
+}
+
+// search runs the full text search given by the url parameters query
+func search(t *testing.T, store AddressStore, query string) []Address {
+	t.Helper()
+	v, err := url.ParseQuery(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := parseFTSParams(v)
+	if err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	addresses, err := store.Search(ctx, p)
+	if err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+	return addresses
+}
+
+func TestStoreSearch(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			addresses := search(t, store, "q=Akademiestr.+2&autocomplete=0")
+			if len(addresses) == 0 || *addresses[0].ADRCD != "1" {
+				t.Fatalf("Akademiestr. 2: got %d addresses, want adrcd 1 first", len(addresses))
+			}
+			if addresses[0].HausnrAnzeige != "2" || addresses[0].Bezirksname == nil {
+				t.Errorf("Akademiestr. 2: got %+v", addresses[0])
+			}
+
+			var scores []float64
+			for _, query := range []string{"q=Akademiestraße+3a", "q=Akademiestraße+3A"} {
+				addresses := search(t, store, query+"&autocomplete=0")
+				if len(addresses) != 1 || *addresses[0].ADRCD != "2" || addresses[0].Score == nil {
+					t.Fatalf("%s: got %d addresses, want adrcd 2", query, len(addresses))
+				}
+				scores = append(scores, *addresses[0].Score)
+			}
+			if scores[0] != scores[1] {
+				t.Errorf("house number letter: score %v for 3a, %v for 3A", scores[0], scores[1])
+			}
+			if addresses := search(t, store, "street=Maria-Theresien-Straße&housenumber=18"); len(addresses) != 1 || *addresses[0].ADRCD != "3" {
+				t.Errorf("structured query: got %d addresses, want adrcd 3", len(addresses))
+			}
+			if addresses := search(t, store, "q=Akademiestraße&postcode=6020"); len(addresses) != 0 {
+				t.Errorf("postcode filter: got %d addresses, want none", len(addresses))
+			}
+			if addresses := search(t, store, "q=Akademiestraße&district=901"); len(addresses) != 2 {
+				t.Errorf("district filter: got %d addresses, want 2", len(addresses))
+			}
+		})
+	}
+}
+
+func TestStoreSearchProvince(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			for query, want := range map[string]int{
+				"q=Akademiestraße&province=9":  2,
+				"q=Akademiestraße&province=09": 2,
+				"q=Akademiestraße&province=7":  0,
+			} {
+				if addresses := search(t, store, query); len(addresses) != want {
+					t.Errorf("%s: got %d addresses, want %d", query, len(addresses), want)
+				}
+			}
+		})
+	}
+
+	for _, query := range []string{"q=x&province=0", "q=x&province=10", "q=x&province=9%25", "q=x&province=Wien"} {
+		v, _ := url.ParseQuery(query)
+		if _, err := parseFTSParams(v); err == nil {
+			t.Errorf("%s: no error", query)
+		}
+	}
+}
+
+func TestStoreSearchNoCandidates(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			for _, query := range []string{
+				"q=Xyzzyplatz",
+				"q=Akademiestraße+Xyzzyplatz",
+				"q=Xyzzyplatz&fuzzy=1",
+				"q=Akademiestraße&lat=48.2&lon=-100",
+				"q=Akademiestraße&lat=90&lon=0",
+				"q=Akademiestraße&lat=-90&lon=180",
+			} {
+				if addresses := search(t, store, query); len(addresses) != 0 {
+					t.Errorf("%s: got %d addresses, want none", query, len(addresses))
+				}
+			}
+		})
+	}
+}
+
+func TestSearchCoordinatesOutOfRange(t *testing.T) {
+	for _, query := range []string{
+		"q=x&lat=21474836.475&lon=0",
+		"q=x&lat=0&lon=181",
+		"q=x&lat=NaN&lon=0",
+		"q=x&lat=0&lon=NaN",
+		"q=x&lat=48",
+	} {
+		v, _ := url.ParseQuery(query)
+		if _, err := parseFTSParams(v); err == nil {
+			t.Errorf("%s: no error", query)
+		}
+	}
+}
+
+func TestMemStoreNearbyPoles(t *testing.T) {
+	m := &memStore{grid: map[gridCell][]int32{}}
+	for _, lat := range []float64{90, -90, 89.99999} {
+		done := make(chan struct{})
+		go func() {
+			m.nearby(lat, 0, maxradiusReverse)
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("nearby(%v, 0) does not return", lat)
+		}
+	}
+}
+
+func TestStoreReverse(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			d, err := store.Detail(ctx, "1", "")
+			if err != nil || d == nil || d.LatlongX == nil {
+				t.Fatalf("Detail(1) = %v, %v", d, err)
+			}
+
+			addresses, err := store.Reverse(ctx, &reverseParams{lat: *d.LatlongY, lon: *d.LatlongX, radius: 100, n: 5})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(addresses) != 2 || *addresses[0].ADRCD != "1" || addresses[0].Distance == nil {
+				t.Errorf("got %d addresses, want adrcd 1 and 2 by distance", len(addresses))
+			}
+		})
+	}
+}
+
+func TestStoreDetail(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			d, err := store.Detail(ctx, "1", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d == nil || len(d.Buildings) != 2 || d.Bundesland != "9" || !d.building("002") {
+				t.Errorf("Detail(1) = %+v", d)
+			}
+
+			if d, err := store.Detail(ctx, "99", ""); err != nil || d != nil {
+				t.Errorf("Detail(99) = %v, %v, want nil", d, err)
+			}
+			if d, err := store.Detail(ctx, "1", "2000-01-01"); err != nil || d != nil {
+				t.Errorf("Detail(1) before the release = %v, %v, want nil", d, err)
+			}
+		})
+	}
+}
+
+func TestStoreCatalogue(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			provinces, err := store.Catalogue(ctx, levelProvinces, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			counts := make(map[string]int64)
+			for _, e := range provinces {
+				counts[e.Code] = e.Count
+			}
+			if counts["9"] != 2 || counts["7"] != 1 {
+				t.Errorf("provinces = %+v", provinces)
+			}
+
+			numbers, err := store.HouseNumbers(ctx, "001", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(numbers) != 2 || numbers[0].HausnrAnzeige != "2" || numbers[1].HausnrAnzeige != "3a" {
+				t.Errorf("house numbers = %+v", numbers)
+			}
+		})
+	}
+}
+
+func TestStorePostcode(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			p, err := store.Postcode(context.Background(), "1010")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.Count != 2 || len(p.BBox) != 4 || len(p.Streets) != 1 {
+				t.Errorf("Postcode(1010) = %+v", p)
+			}
+		})
+	}
+}